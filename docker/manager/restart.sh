@@ -1,5 +1,6 @@
 #!/bin/sh
 # restart a container remotely. input format: 123.45.56.78:12345
+# the api server gets 15 seconds (its drain delay and grace period) to drain its connections before it is killed
 # if the container does not exist we try to spawn a new container that listens to the correct port
 
 host=$(echo $1 |cut -d':' -f1)
@@ -11,7 +12,7 @@ if [ "$cid" == "" ]; then
         echo "no container found at $host:$port"
         echo $(0</dev/null ssh $host docker run -d -p $port:80 sfmovies/apiserver) started
 else
-        echo $(0</dev/null ssh $host docker restart $cid) restarted
+        echo $(0</dev/null ssh $host docker restart -t 15 $cid) restarted
 fi
 
 sleep 1s
@@ -1,5 +1,6 @@
 #!/bin/sh
 # performs a rolling restart of all the api servers listed in the api_servers file
+# the api servers reload new data by themselves, this is only needed when deploying a new version
 # the restart.sh script restarts a container and finishes 
 # only when the restarted api server is handling requests
 
@@ -8,8 +8,11 @@ import (
 	"flag"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/CorgiMan/sfmovies/gocode"
@@ -18,11 +21,20 @@ import (
 // listens on port 80 by default
 var port = flag.String("port", "80", "port that program listens on")
 
-// Data used by API server
+// how often the server checks MongoDB for a newer data set
+var reloadInterval = flag.Duration("reload", time.Minute, "interval between checks for newer API data")
+
+// how long outstanding requests may take to finish when the server shuts down
+var gracePeriod = flag.Duration("grace", 10*time.Second, "time to wait for outstanding requests on shutdown")
+
+// how long the server keeps accepting requests on shutdown, so the load balancer and the monitor see it draining
+var drainDelay = flag.Duration("drain", 5*time.Second, "time to keep serving while /status reports draining on shutdown")
+
+// Data used by API server. The data and the trie are bundled in an apiState which is
+// swapped atomically when newer data is loaded, see apiserver_reload.go.
 var (
-	appData *sfmovies.APIData
-	trie    *TrieNode
-	status  Status
+	state  atomic.Pointer[apiState]
+	status Status
 )
 
 // The status of an API server. This is used when "/status" is requested
@@ -30,19 +42,22 @@ type Status struct {
 	APIVersion   string
 	RunningSince time.Time
 	DataVersion  time.Time
+	Draining     bool
+	// the cache of search and auto-complete responses, see apiserver_respcache.go
+	ResponseCache ResponseCacheStats
 }
 
-// API servers errors are served by encoding this struct to JSON
+// API servers errors are served by encoding this struct to JSON, see apiserver_errors.go
 type Error struct {
-	Error string
+	Error *APIError
 }
 
 // Download the latest APIData from MongoDB, calculate the search trie and determine status of the server.
-func init() {
+// Then sets up the webserver on a port specified by the --port flag
+func main() {
 	flag.Parse()
 
-	var err error
-	appData, err = sfmovies.GetLatestAPIData()
+	appData, err := sfmovies.GetLatestAPIData()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -52,43 +67,78 @@ func init() {
 	status = Status{}
 	status.APIVersion = sfmovies.APIVersion
 	status.RunningSince = time.Now()
-	status.DataVersion = appData.Time
 
-	trie = CreateTrie(appData)
-}
+	state.Store(newAPIState(appData))
+	responses = NewResponseCache(*responseCacheSize)
+	go watchAPIData(*reloadInterval)
 
-// Sets up the webserver on a port specified by the --port flag
-func main() {
+	cors := NewCORSPolicy(*corsOrigins)
+	// the cache handler is outside the JSONP handler since 304 responses have no body to pad
+	api := func(fn Handler) Handler {
+		return cors.Handler(compressHandler(getHandler(cacheHandler(jsonpHandler(fn)))))
+	}
 	// root handles near, search and complete queries as well as API description
-	http.HandleFunc("/", jsonpHandler(rootHandler))
-	http.HandleFunc("/movies/", jsonpHandler(moviesHandler))
-	http.HandleFunc("/status", jsonpHandler(statusHandler))
-	err := http.ListenAndServe(":"+*port, nil)
+	http.HandleFunc("/", api(rootHandler))
+	http.HandleFunc("/movies/", api(moviesHandler))
+	http.HandleFunc("/scenes/", api(scenesHandler))
+	http.HandleFunc("/locations/", api(locationsHandler))
+	http.HandleFunc("/people", api(peopleHandler))
+	http.HandleFunc("/people/", api(peopleHandler))
+	// the status changes while the data doesn't, so it is never cached
+	http.HandleFunc("/status", cors.Handler(compressHandler(jsonpHandler(getHandler(statusHandler)))))
+	// exports are not JSON, so they are never padded
+	http.HandleFunc("/export.csv", cors.Handler(compressHandler(getHandler(exportCSVHandler))))
+	http.HandleFunc("/export.ndjson", cors.Handler(compressHandler(getHandler(exportNDJSONHandler))))
+	http.HandleFunc("/metrics", compressHandler(getHandler(metricsEndpointHandler)))
+	srv := &http.Server{Addr: ":" + *port, Handler: http.HandlerFunc(metricsHandler(http.DefaultServeMux))}
+	err = serve(srv, *drainDelay, *gracePeriod)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-// Handles near, search, complete and root (usage) queries
+// Handles near, within, search, complete and root (usage) queries
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/near":
 		nearHandler(w, r)
 	case "/search":
-		searchHandler(w, r)
+		responses.Serve(w, r, searchHandler)
 	case "/complete":
-		completeHandler(w, r)
+		responses.Serve(w, r, completeHandler)
+	case "/within":
+		withinHandler(w, r)
 	case "/":
 		_, err := io.WriteString(w, sfmovies.Usage)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, err)
 		}
+	default:
+		writeError(w, r, errNotFound)
 	}
 }
 
-// Encodes an object into JSON and writes to the response writer
-func writeResult(w http.ResponseWriter, v interface{}) {
-	bts, err1 := json.MarshalIndent(v, "", "  ")
+const jsonType = "application/json; charset=utf-8"
+
+// Sets the JSON content type unless another type was set, e.g. by jsonpHandler. Must be called
+// before WriteHeader.
+func setJSONType(w http.ResponseWriter) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", jsonType)
+	}
+}
+
+// Encodes an object into JSON and writes to the response writer. The JSON is compact unless
+// the pretty parameter is set, e.g. pretty=1.
+func writeResult(w http.ResponseWriter, r *http.Request, v interface{}) {
+	setJSONType(w)
+	var bts []byte
+	var err1 error
+	if pretty, _ := strconv.ParseBool(r.FormValue("pretty")); pretty {
+		bts, err1 = json.MarshalIndent(v, "", "  ")
+	} else {
+		bts, err1 = json.Marshal(v)
+	}
 	_, err2 := w.Write(bts)
 	if err1 != nil || err2 != nil {
 		http.Error(w, "failed to marshal and write json", http.StatusInternalServerError)
@@ -97,102 +147,320 @@ func writeResult(w http.ResponseWriter, v interface{}) {
 
 // Writes the status of the API server
 func statusHandler(w http.ResponseWriter, r *http.Request) {
-	writeResult(w, status)
+	s := status
+	s.DataVersion = state.Load().Data.Time
+	s.Draining = draining.Load()
+	s.ResponseCache = responses.Stats()
+	if s.Draining {
+		// tells the load balancer and the monitor to stop routing requests to this server
+		setJSONType(w)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeResult(w, r, s)
 }
 
-// Handles queries for a specific IMDB movie ID.
+// Handles queries for a specific IMDB movie ID. /movies/{imdbid}/scenes lists the scenes of the movie.
 func moviesHandler(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
 	imdbid := r.URL.Path[len("/movies/"):]
-	if movie, ok := appData.Movies[imdbid]; ok {
-		writeResult(w, movie)
+	if id := strings.TrimSuffix(imdbid, "/scenes"); id != imdbid {
+		if _, ok := st.Data.Movies[id]; !ok {
+			writeError(w, r, errNotFound)
+			return
+		}
+		p, err := parsePage(r, st.Data.Time, sfmovies.SceneQuerySize, sfmovies.MaxSceneQuerySize)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		scenes := st.Resources.MovieScenes(id)
+		result := SceneResults{paginate(scenes, p), p.page(r, len(scenes))}
+		writeScenes(w, r, st, &result)
+		return
+	}
+	if movie, ok := st.Data.Movies[imdbid]; ok {
+		writeResult(w, r, movie)
+	} else {
+		writeError(w, r, errNotFound)
+	}
+}
+
+// Handles queries for a specific scene ID. Returns the scene with its movie.
+func scenesHandler(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+	scene := st.Resources.Scene(r.URL.Path[len("/scenes/"):], st.Data)
+	format, err := parseFormat(w, r)
+	switch {
+	case err != nil:
+		writeError(w, r, err)
+	case scene == nil:
+		writeError(w, r, errNotFound)
+	case format == formatJSON:
+		writeResult(w, r, scene)
+	case format == formatGeoJSON:
+		writeGeoJSON(w, r, st.sceneFeature(scene.Scene))
+	default:
+		writeFeatures(w, r, format, newFeatureCollection([]*Feature{st.sceneFeature(scene.Scene)}, Page{Total: 1}))
+	}
+}
+
+// Handles queries for a specific location ID. Returns every movie and scene filmed at the location.
+func locationsHandler(w http.ResponseWriter, r *http.Request) {
+	if loc := state.Load().Resources.Location(r.URL.Path[len("/locations/"):]); loc != nil {
+		writeResult(w, r, loc)
 	} else {
-		writeResult(w, Error{"Recource not found"})
+		writeError(w, r, errNotFound)
 	}
 }
 
-// Handles auto-complete queries.
+// Handles /people?q=, which returns the people whose name matches the query, and /people/{slug},
+// which returns a person with their roles, movies and filming locations.
+func peopleHandler(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+	slug := strings.Trim(strings.TrimPrefix(r.URL.Path, "/people"), "/")
+	if slug != "" {
+		if person := st.People.Get(slug); person != nil {
+			writeResult(w, r, person)
+		} else {
+			writeError(w, r, errNotFound)
+		}
+		return
+	}
+
+	p, err := parsePage(r, st.Data.Time, sfmovies.PeopleQuerySize, sfmovies.MaxPeopleQuerySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	people := st.People.Find(r.FormValue("q"))
+	writeResult(w, r, PeopleResults{paginate(people, p), p.page(r, len(people))})
+}
+
+// A page of the people whose name matches a query.
+type PeopleResults struct {
+	People []PersonSummary
+	Page
+}
+
+// A page of the scenes of a movie.
+type SceneResults struct {
+	Scenes []*SceneInfo
+	Page
+}
+
+// A page of auto-complete suggestions.
+type CompleteResults struct {
+	Completions []*Completion
+	Page
+}
+
+// Handles auto-complete queries. Returns titles, names and locations with their type.
 func completeHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.FormValue("term")
-	result := trie.GetFrom(q, sfmovies.AutoCompleteQuerySize)
-	writeResult(w, result)
+	maxDist, err := parseFuzzy(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	st := requestState(r)
+	p, err := parsePage(r, st.Data.Time, sfmovies.AutoCompleteQuerySize, sfmovies.MaxAutoCompleteQuerySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	completions := st.Trie.Complete(q, p.countLimit(), maxDist)
+	writeResult(w, r, CompleteResults{paginate(completions, p), p.boundedPage(r, len(completions))})
 }
 
-// Handles queries that search for a complete word.
+// Handles queries that search for complete words. By default scenes have to match every word,
+// with op=or scenes that match any of the words are returned. Typos are corrected unless fuzzy=0.
+// The results can be filtered by year, genre, rating, director and IMDB ID, see apiserver_filter.go.
 func searchHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.FormValue("q")
-	if result := trie.Get(appData, q); result != nil {
-		writeResult(w, result)
-	} else {
-		writeResult(w, Error{"Recource not found"})
+	var matchAll bool
+	switch r.FormValue("op") {
+	case "", "and":
+		matchAll = true
+	case "or":
+		matchAll = false
+	default:
+		writeError(w, r, invalidParam("op", "op must be and or or"))
+		return
+	}
+	maxDist, err := parseFuzzy(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filter, err := ParseFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	st := requestState(r)
+	p, err := parsePage(r, st.Data.Time, sfmovies.SearchQuerySize, sfmovies.MaxSearchQuerySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(ParseQuery(q)) == 0 {
+		writeError(w, r, invalidParam("q", "q must contain at least one word"))
+		return
+	}
+	result := st.Trie.Get(st.Data, q, matchAll, maxDist)
+	if result == nil {
+		// a query without results is not an error
+		result = &SearchResults{Movies: []*ScoredMovie{}, Scenes: []*ScoredScene{}}
+	}
+	result.filter(filter, st.Facets)
+	// movies and scenes are paged together, there is a next page while either has more results
+	result.Page = p.page(r, max(len(result.Scenes), len(result.Movies)))
+	result.Total = len(result.Scenes)
+	result.TotalMovies = len(result.Movies)
+	result.Movies = paginate(result.Movies, p)
+	result.Scenes = paginate(result.Scenes, p)
+	writeScenes(w, r, st, result)
+}
+
+// A page of the scenes closest to a location.
+type NearResults struct {
+	Scenes []NearScene
+	Page
+}
+
+// Parses the latitude in degrees in the parameter, it must be between -90 and 90.
+func parseLatitude(r *http.Request, param string) (float64, error) {
+	lat, err := strconv.ParseFloat(r.FormValue(param), 64)
+	if err != nil || !(lat >= -90 && lat <= 90) {
+		return 0, invalidParam(param, param+" must be a latitude in degrees between -90 and 90")
 	}
+	return lat, nil
 }
 
-// Handles near queries. Returns the closest NearQuerySize points-of-interest.
+// Parses the longitude in degrees in the parameter, it must be between -180 and 180.
+func parseLongitude(r *http.Request, param string) (float64, error) {
+	lng, err := strconv.ParseFloat(r.FormValue(param), 64)
+	if err != nil || !(lng >= -180 && lng <= 180) {
+		return 0, invalidParam(param, param+" must be a longitude in degrees between -180 and 180")
+	}
+	return lng, nil
+}
+
+// Handles near queries. Returns the closest points-of-interest using the k-d tree, at most limit
+// (NearQuerySize by default) and only those within radius meters if the radius parameter is set.
+// The results can be filtered like search results.
 func nearHandler(w http.ResponseWriter, r *http.Request) {
-	lat, err1 := strconv.ParseFloat(r.FormValue("lat"), 64)
-	lng, err2 := strconv.ParseFloat(r.FormValue("lng"), 64)
-	if err1 != nil || err2 != nil {
-		http.Error(w, "failed to parse lat and lng parameters", http.StatusInternalServerError)
+	lat, err := parseLatitude(r, "lat")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	lng, err := parseLongitude(r, "lng")
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	loc := sfmovies.Location{"", lat, lng}
-	ds := make([]float64, 0)
-	scs := make([]*sfmovies.Scene, 0)
-	for _, scene := range appData.Scenes {
-		ds = append(ds, loc.Distance(scene.Location))
-		scs = append(scs, scene)
+	st := state.Load()
+	p, err := parsePage(r, st.Data.Time, sfmovies.NearQuerySize, sfmovies.MaxNearQuerySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	radius := math.Inf(1)
+	if v := r.FormValue("radius"); v != "" {
+		radius, err = strconv.ParseFloat(v, 64)
+		if err != nil || !(radius > 0) {
+			writeError(w, r, invalidParam("radius", "radius must be a positive number of meters"))
+			return
+		}
+	}
+	filter, err := ParseFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	loc := sfmovies.Location{Lat: lat, Lng: lng}
+	accept := filter.sceneFilter(st.Facets)
+	scenes := st.Spatial.Nearest(&loc, p.end(), radius, accept)
+	total := st.Spatial.Count(&loc, radius, accept, p.countLimit())
+	result := NearResults{paginate(scenes, p), p.boundedPage(r, total)}
+	writeScenes(w, r, st, &result)
+}
 
-	// select closest element NearQuerySize times
-	result := make([]*sfmovies.Scene, 0)
-	for i := 0; i < sfmovies.NearQuerySize; i++ {
-		// select nearest element
-		ix := minix(ds)
-		if ix == -1 {
-			break
+// A page of the scenes inside a bounding box.
+type WithinResults struct {
+	Scenes []*sfmovies.Scene
+	Page
+}
+
+// Handles bounding box queries. Returns at most limit scenes inside the box, starting at the cursor.
+func withinHandler(w http.ResponseWriter, r *http.Request) {
+	var min, max sfmovies.Location
+	var errs [4]error
+	min.Lat, errs[0] = parseLatitude(r, "minLat")
+	min.Lng, errs[1] = parseLongitude(r, "minLng")
+	max.Lat, errs[2] = parseLatitude(r, "maxLat")
+	max.Lng, errs[3] = parseLongitude(r, "maxLng")
+	for _, err := range errs {
+		if err != nil {
+			writeError(w, r, err)
+			return
 		}
-		result = append(result, scs[ix])
+	}
+	if min.Lat > max.Lat {
+		writeError(w, r, invalidParam("minLat", "minLat must not exceed maxLat"))
+		return
+	}
+	if min.Lng > max.Lng {
+		writeError(w, r, invalidParam("minLng", "minLng must not exceed maxLng"))
+		return
+	}
 
-		// remove it from the array so that the next closest element will be found next time
-		ds[ix] = ds[len(ds)-1]
-		scs[ix] = scs[len(scs)-1]
-		ds = ds[:len(ds)-1]
-		scs = scs[:len(scs)-1]
+	st := state.Load()
+	p, err := parsePage(r, st.Data.Time, sfmovies.WithinQuerySize, sfmovies.MaxWithinQuerySize)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	writeResult(w, result)
+	scenes := make([]*sfmovies.Scene, 0)
+	total := 0
+	st.Spatial.VisitWithin(&min, &max, func(scene *sfmovies.Scene) bool {
+		if p.offset <= total && total < p.end() {
+			scenes = append(scenes, scene)
+		}
+		total++
+		return true
+	})
+	result := WithinResults{scenes, p.page(r, total)}
+	writeScenes(w, r, st, &result)
 }
 
-// Returns the index of the smallest element in a.
-func minix(a []float64) int {
-	if len(a) == 0 {
-		return -1
+// Parses the limit parameter. Returns def if it is not set.
+func parseLimit(r *http.Request, def, max int) (int, error) {
+	v := r.FormValue("limit")
+	if v == "" {
+		return def, nil
 	}
-	mini := 0
-	for i := range a {
-		if a[i] < a[mini] {
-			mini = i
-		}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > max {
+		return 0, invalidParam("limit", "limit must be a number between 1 and "+strconv.Itoa(max))
 	}
-	return mini
+	return limit, nil
 }
 
-type Handler func(http.ResponseWriter, *http.Request)
-
-// Wraps around all other handlers and adds JSONP padding only if the callback parameter is set.
-func jsonpHandler(fn Handler) Handler {
-	return func(w http.ResponseWriter, r *http.Request) {
-		callback := r.FormValue("callback")
-		if callback != "" {
-			w.Header().Add("Content-Type", "application/javascript")
-			_, err1 := w.Write([]byte(callback + "("))
-			fn(w, r)
-			_, err2 := w.Write([]byte(");"))
-			if err1 != nil || err2 != nil {
-				http.Error(w, "failed to write JSONP padding", http.StatusInternalServerError)
-			}
-		} else {
-			fn(w, r)
-		}
+// Parses the fuzzy parameter, the maximum number of typos corrected per word.
+// Returns MaxEditDistance if it is not set.
+func parseFuzzy(r *http.Request) (int, error) {
+	v := r.FormValue("fuzzy")
+	if v == "" {
+		return sfmovies.MaxEditDistance, nil
 	}
+	d, err := strconv.Atoi(v)
+	if err != nil || d < 0 || d > sfmovies.MaxEditDistance {
+		return 0, invalidParam("fuzzy", "fuzzy must be a number between 0 and "+strconv.Itoa(sfmovies.MaxEditDistance))
+	}
+	return d, nil
 }
+
+type Handler func(http.ResponseWriter, *http.Request)
@@ -0,0 +1,126 @@
+// HTTP caching. The data changes at most once a day, so a response only depends on the data
+// version, the query and the requested format. Every response carries a weak ETag derived from
+// those, a Last-Modified header set to the data version and the Cache-Control header configured
+// with --cache-control. Conditional requests with If-None-Match or If-Modified-Since are answered
+// with 304 Not Modified before the query is run, so nginx and browsers can cache safely.
+// The ETags are weak since the body can be compressed differently.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cacheControl = flag.String("cache-control", "public, max-age=60", "Cache-Control header of API responses")
+
+// Returns the query with its parameters sorted by name and without empty parameters, so equal
+// queries have the same canonical form.
+func canonicalQuery(r *http.Request) string {
+	q := r.URL.Query()
+	for name, values := range q {
+		if len(values) == 1 && values[0] == "" {
+			delete(q, name)
+		}
+	}
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// Returns the ETag of the response to the request for data of the given version. The Accept
+// header is part of it since it selects the format of the response.
+func responseETag(r *http.Request, version time.Time) string {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(canonicalQuery(r) + "\n" + r.Header.Get("Accept")))
+	return fmt.Sprintf(`W/"%s-%x"`, strconv.FormatInt(version.UnixNano(), 36), hasher.Sum64())
+}
+
+// Sets the ETag and Last-Modified headers of a response that only changes with the data version.
+// Returns true and writes 304 Not Modified if the client already has the current version.
+// If-None-Match takes precedence over If-Modified-Since, as RFC 7232 requires.
+func notModified(w http.ResponseWriter, r *http.Request, version time.Time, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", version.UTC().Format(http.TimeFormat))
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// weak comparison, see RFC 7232 section 2.3.2
+		opaque := strings.TrimPrefix(etag, "W/")
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == opaque {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !version.Truncate(time.Second).After(ims) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// Adds the header name to the Vary header unless it is already there.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, n := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(n), name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// Adds the caching headers to the responses of fn and answers conditional requests.
+func cacheHandler(fn Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := state.Load().Data.Time
+		w.Header().Set("Cache-Control", *cacheControl)
+		if notModified(w, r, version, responseETag(r, version)) {
+			return
+		}
+		fn(&cacheWriter{ResponseWriter: w}, r)
+	}
+}
+
+// Implemented by writers that need the status of a response that is sent with another HTTP
+// status, see jsonpHandler.
+type statusReporter interface {
+	ReportStatus(code int)
+}
+
+// Drops the caching headers of errors, which may not happen again, once the status is known.
+type cacheWriter struct {
+	http.ResponseWriter
+	reported bool
+}
+
+// Sets the caching headers for a response with the status. Only the first status counts.
+func (w *cacheWriter) ReportStatus(code int) {
+	if w.reported {
+		return
+	}
+	w.reported = true
+	if code >= 400 {
+		w.Header().Del("ETag")
+		w.Header().Del("Last-Modified")
+		w.Header().Set("Cache-Control", "no-store")
+	}
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	w.ReportStatus(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	w.ReportStatus(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
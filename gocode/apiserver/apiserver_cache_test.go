@@ -0,0 +1,124 @@
+// Tests for apiserver_cache.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalQuery(t *testing.T) {
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"/near?lat=37.7&lng=-122.4", "/near?lng=-122.4&lat=37.7", true},
+		{"/near?lat=37.7&lng=-122.4&radius=", "/near?lng=-122.4&lat=37.7", true},
+		{"/search?q=vertigo", "/search?q=Vertigo", false},
+		{"/search?q=vertigo", "/complete?q=vertigo", false},
+		{"/search?q=a&q=b", "/search?q=b&q=a", false},
+	}
+	for _, c := range cases {
+		a := canonicalQuery(httptest.NewRequest("GET", c.a, nil))
+		b := canonicalQuery(httptest.NewRequest("GET", c.b, nil))
+		if (a == b) != c.equal {
+			t.Errorf("canonicalQuery(%q) == %q and canonicalQuery(%q) == %q", c.a, a, c.b, b)
+		}
+	}
+}
+
+func TestCacheHandler(t *testing.T) {
+	ad := resourcesTestData()
+	ad.Time = time.Date(2015, 3, 1, 4, 0, 0, 0, time.UTC)
+	defer serveTestData(ad)()
+
+	calls := 0
+	handler := cacheHandler(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		rootHandler(w, r)
+	})
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	w := get("/near?lat=37.78&lng=-122.42")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Header().Get("Last-Modified") != "Sun, 01 Mar 2015 04:00:00 GMT" || w.Header().Get("Cache-Control") != *cacheControl {
+		t.Fatalf("/near returned %v with headers %v", w.Code, w.Header())
+	}
+	if other := get("/near?lng=-122.42&lat=37.78").Header().Get("ETag"); other != etag {
+		t.Errorf("Equal queries have ETags %v and %v", etag, other)
+	}
+	for _, path := range []string{"/near?lat=37.78&lng=-122.43", "/near?lat=37.78&lng=-122.42&format=geojson"} {
+		if other := get(path).Header().Get("ETag"); other == etag {
+			t.Errorf("%v has the same ETag as another query", path)
+		}
+	}
+	if other := get("/near?lat=37.78&lng=-122.42", "Accept", gpxType).Header().Get("ETag"); other == etag {
+		t.Errorf("Another format has the same ETag")
+	}
+
+	calls = 0
+	for _, header := range [][]string{
+		{"If-None-Match", etag},
+		{"If-None-Match", etag[2:]},
+		{"If-Modified-Since", "Sun, 01 Mar 2015 04:00:00 GMT"},
+	} {
+		w = get("/near?lat=37.78&lng=-122.42", header...)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("%v: %v returned %v", header[0], header[1], w.Code)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("Conditional requests ran the query %v times", calls)
+	}
+
+	// newer data invalidates the ETag
+	newer := resourcesTestData()
+	newer.Time = ad.Time.Add(24 * time.Hour)
+	swapAPIData(newer)
+	if w = get("/near?lat=37.78&lng=-122.42", "If-None-Match", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("Newer data returned %v with ETag %v", w.Code, w.Header().Get("ETag"))
+	}
+
+	// server errors are not cached
+	w = httptest.NewRecorder()
+	cacheHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Server error has headers %v", w.Header())
+	}
+
+	// neither are client errors, also not when they are sent as JSONP with status 200
+	handler = cacheHandler(jsonpHandler(rootHandler))
+	cases := []struct {
+		path   string
+		cached bool
+	}{
+		{"/near?lat=37.78&lng=-122.42", true},
+		{"/near?lat=37.78&lng=-122.42&callback=cb", true},
+		{"/near?lat=x&lng=-122.42", false},
+		{"/near?lat=x&lng=-122.42&callback=cb", false},
+		{"/unknown?callback=cb", false},
+	}
+	for _, c := range cases {
+		w := get(c.path)
+		cached := w.Header().Get("ETag") != "" && w.Header().Get("Cache-Control") == *cacheControl
+		if cached != c.cached || !c.cached && w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%v returned %v with headers %v", c.path, w.Code, w.Header())
+		}
+	}
+	errorWithCallback := get("/near?lat=x&lng=-122.42&callback=cb")
+	if body := errorWithCallback.Body.String(); errorWithCallback.Code != http.StatusOK || !strings.HasPrefix(body, "/**/cb(") || !strings.HasSuffix(body, ", 400);") {
+		t.Errorf("JSONP error returned %v %v", errorWithCallback.Code, body)
+	}
+}
@@ -0,0 +1,172 @@
+// Response compression. Responses are compressed with brotli or gzip, whichever the client
+// prefers in its Accept-Encoding header, brotli if it likes both equally. Search results for
+// common words are large and compress well: see BenchmarkSearchResponse for the sizes.
+// Responses smaller than minCompressSize are sent as they are, compressing them doesn't pay off.
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	minCompressSize = 1024
+	// lower than the default quality of 11, which is too slow for responses that are computed per request
+	brotliQuality = 4
+)
+
+// The encoders are reused, allocating their buffers for every response is expensive.
+var (
+	gzipPool   = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	brotliPool = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(nil, brotliQuality) }}
+)
+
+// Returns the encoding the client prefers among br and gzip, or "" if it accepts neither.
+// An explicit q value for br or gzip takes precedence over the q value of *.
+func negotiateEncoding(acceptEncoding string) string {
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if coding == "br" || coding == "gzip" || coding == "*" {
+			qs[coding] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		q, ok := qs[coding]
+		if !ok {
+			q = qs["*"]
+		}
+		// br wins ties since it is checked first
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Compresses the responses of fn if the client accepts it.
+func compressHandler(fn Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			fn(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+		fn(cw, r)
+	}
+}
+
+// Buffers the start of a response to decide whether it is worth compressing.
+// The status is sent when that is decided.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	wroteHeader bool
+	buf         []byte
+	enc         io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= minCompressSize {
+		if err := w.startEncoding(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Sends the headers and the buffered start of the response through the encoder.
+func (w *compressWriter) startEncoding() error {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		// already encoded, send it as it is
+		w.enc = nopCloser{w.ResponseWriter}
+	} else {
+		// the type can't be sniffed from the compressed body
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		switch w.encoding {
+		case "br":
+			bw := brotliPool.Get().(*brotli.Writer)
+			bw.Reset(w.ResponseWriter)
+			w.enc = pooledWriter{bw, &brotliPool}
+		case "gzip":
+			gw := gzipPool.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.enc = pooledWriter{gw, &gzipPool}
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.enc.Write(buf)
+	return err
+}
+
+// Finishes the response. Responses that stayed small are sent uncompressed.
+func (w *compressWriter) Close() error {
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	if w.wroteHeader || len(w.buf) > 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
+
+// An encoder that is returned to its pool when it is closed.
+type pooledWriter struct {
+	io.WriteCloser
+	pool *sync.Pool
+}
+
+func (w pooledWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.pool.Put(w.WriteCloser)
+	return err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,171 @@
+// Tests for apiserver_compress.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0.1", "gzip"},
+		{"GZIP", "gzip"},
+		{"*", "br"},
+		{"gzip;q=0", ""},
+		{"gzip;q=x", ""},
+		{"br;q=0, *", "gzip"},
+		{"*, br;q=0", "gzip"},
+		{"br;q=0, gzip;q=0, *", ""},
+		{"*;q=0.5, gzip", "gzip"},
+		{"*;q=0", ""},
+	}
+	for _, c := range cases {
+		if got := negotiateEncoding(c.in); got != c.out {
+			t.Errorf("negotiateEncoding(%q) == %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+// Decodes the body of a response with the encoding in its Content-Encoding header.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) []byte {
+	var r io.Reader = w.Body
+	switch w.Header().Get("Content-Encoding") {
+	case "gzip":
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(w.Body)
+	}
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bts
+}
+
+func TestCompressHandler(t *testing.T) {
+	defer serveTestData(testAPIData(300))()
+	handler := compressHandler(rootHandler)
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	plain := get("/search?q=movie&limit=300", "")
+	if plain.Header().Get("Content-Encoding") != "" || plain.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Uncompressed response has headers %v", plain.Header())
+	}
+	for _, encoding := range []string{"gzip", "br"} {
+		w := get("/search?q=movie&limit=300", encoding)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != encoding {
+			t.Errorf("%v response returned %v with headers %v", encoding, w.Code, w.Header())
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != jsonType {
+			t.Errorf("%v response has Content-Type %q, want %q", encoding, ct, jsonType)
+		}
+		if w.Body.Len() >= plain.Body.Len()/2 {
+			t.Errorf("%v response is %v bytes, uncompressed %v", encoding, w.Body.Len(), plain.Body.Len())
+		}
+		if body := decodeBody(t, w); !bytes.Equal(body, plain.Body.Bytes()) {
+			t.Errorf("Decoded %v response differs from the uncompressed response", encoding)
+		}
+	}
+
+	// small responses and errors keep their status and are not compressed
+	w := get("/search?q=", "gzip")
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), codeInvalidParameter) {
+		t.Errorf("Small error response returned %v %v with headers %v", w.Code, w.Body.String(), w.Header())
+	}
+	// responses without a content type are sniffed before they are compressed
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	compressHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("plain text ", 200)))
+	})(w, r)
+	if ct := w.Header().Get("Content-Type"); w.Header().Get("Content-Encoding") != "gzip" || ct != "text/plain; charset=utf-8" {
+		t.Errorf("Compressed response without a type has headers %v", w.Header())
+	}
+	// responses without a body
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	compressHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("304 response returned %v %q with headers %v", w.Code, w.Body.String(), w.Header())
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+	for _, c := range []struct {
+		path   string
+		pretty bool
+	}{
+		{"/scenes/a1", false},
+		{"/scenes/a1?pretty=1", true},
+		{"/scenes/a1?pretty=true", true},
+		{"/scenes/a1?pretty=0", false},
+	} {
+		w := httptest.NewRecorder()
+		scenesHandler(w, httptest.NewRequest("GET", c.path, nil))
+		if pretty := strings.Contains(w.Body.String(), "\n  \""); pretty != c.pretty {
+			t.Errorf("%v returned %v", c.path, w.Body.String())
+		}
+	}
+}
+
+// Reports the size of a search response for a common word in every format and encoding.
+// Run with go test -bench SearchResponse -benchmem.
+func BenchmarkSearchResponse(b *testing.B) {
+	defer serveTestData(testAPIData(1000))()
+	// measure the search, not the response cache
+	defer func(prev *ResponseCache) { responses = prev }(responses)
+	responses = NewResponseCache(0)
+	handler := compressHandler(rootHandler)
+	for _, format := range []string{"", "&pretty=1"} {
+		for _, encoding := range []string{"identity", "gzip", "br"} {
+			name := "compact/"
+			if format != "" {
+				name = "pretty/"
+			}
+			b.Run(name+encoding, func(b *testing.B) {
+				r := httptest.NewRequest("GET", "/search?q=movie&limit=1000"+format, nil)
+				r.Header.Set("Accept-Encoding", encoding)
+				size := 0
+				for i := 0; i < b.N; i++ {
+					w := httptest.NewRecorder()
+					handler(w, r)
+					size = w.Body.Len()
+				}
+				b.ReportMetric(float64(size), "bytes/response")
+			})
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Cross-origin resource sharing, so web pages on other domains (like the frontend) can request
+// plain JSON instead of JSONP. The origins that may do so are set with --cors-origins, a comma
+// separated list of origins like "http://corgiman.infty.nl" or * to allow every origin. The API
+// doesn't use cookies or other credentials, so allowing every origin is safe and the default.
+// Browsers send a preflight OPTIONS request before requests with custom headers, these are
+// answered here and never reach the other handlers.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var corsOrigins = flag.String("cors-origins", "*", "comma separated origins allowed to make cross-origin requests, * allows every origin")
+
+const (
+	corsMethods       = "GET, HEAD"
+	corsHeaders       = "Accept, If-None-Match, If-Modified-Since"
+	corsExposeHeaders = "ETag, Last-Modified, Link"
+	corsMaxAge        = 24 * 60 * 60 // seconds browsers may cache a preflight response
+)
+
+// The origins allowed to make cross-origin requests.
+type CORSPolicy struct {
+	any     bool
+	origins map[string]bool
+}
+
+// Parses a comma separated list of origins. * allows every origin.
+func NewCORSPolicy(origins string) *CORSPolicy {
+	p := &CORSPolicy{origins: make(map[string]bool)}
+	for _, o := range strings.Split(origins, ",") {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		switch o {
+		case "":
+		case "*":
+			p.any = true
+		default:
+			p.origins[strings.ToLower(o)] = true
+		}
+	}
+	return p
+}
+
+// Checks if the origin may make cross-origin requests.
+func (p *CORSPolicy) Allows(origin string) bool {
+	return p.any || p.origins[strings.ToLower(origin)]
+}
+
+// Adds the CORS headers to the responses of fn and answers preflight requests.
+func (p *CORSPolicy) Handler(fn Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := origin != "" && r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !p.any {
+			// the response depends on the origin, caches must not share it between origins,
+			// not even with requests without an Origin header
+			addVary(w.Header(), "Origin")
+		}
+		// with * every response carries the headers, so a cached response can be served to any origin
+		allowed := p.any || origin != "" && p.Allows(origin)
+		if allowed {
+			if p.any {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		if !preflight {
+			if allowed {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+			}
+			fn(w, r)
+			return
+		}
+		// without the allow headers the browser blocks the actual request
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
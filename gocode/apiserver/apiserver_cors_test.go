@@ -0,0 +1,131 @@
+// Tests for apiserver_cors.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	p := NewCORSPolicy(" http://corgiman.infty.nl/, https://Example.com")
+	cases := []struct {
+		origin string
+		out    bool
+	}{
+		{"http://corgiman.infty.nl", true},
+		{"https://example.com", true},
+		{"http://example.com", false},
+		{"http://corgiman.infty.nl.evil.com", false},
+		{"null", false},
+	}
+	for _, c := range cases {
+		if got := p.Allows(c.origin); got != c.out {
+			t.Errorf("Allows(%q) == %v, want %v", c.origin, got, c.out)
+		}
+	}
+	if !NewCORSPolicy("*").Allows("http://anywhere.org") {
+		t.Errorf("* does not allow every origin")
+	}
+	if NewCORSPolicy("").Allows("http://corgiman.infty.nl") {
+		t.Errorf("An empty list allows an origin")
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+	handler := NewCORSPolicy("http://corgiman.infty.nl").Handler(getHandler(scenesHandler))
+
+	// a simple request gets the allow origin header
+	r := httptest.NewRequest("GET", "/scenes/a1", nil)
+	r.Header.Set("Origin", "http://corgiman.infty.nl")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "http://corgiman.infty.nl" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("Allowed origin got %v with headers %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") == "" {
+		t.Errorf("Response does not expose headers")
+	}
+
+	// other origins get the response without the header, so the browser blocks it
+	r.Header.Set("Origin", "http://evil.com")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Disallowed origin got Access-Control-Allow-Origin %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	// preflight
+	r = httptest.NewRequest("OPTIONS", "/scenes/a1", nil)
+	r.Header.Set("Origin", "http://corgiman.infty.nl")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	r.Header.Set("Access-Control-Request-Headers", "If-None-Match")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("Preflight returned %v %v", w.Code, w.Body.String())
+	}
+	for _, h := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers", "Access-Control-Max-Age"} {
+		if w.Header().Get(h) == "" {
+			t.Errorf("Preflight response has no %v header", h)
+		}
+	}
+
+	// an OPTIONS request that is not a preflight is not allowed
+	r = httptest.NewRequest("OPTIONS", "/scenes/a1", nil)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("OPTIONS returned %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	// every origin
+	r = httptest.NewRequest("GET", "/scenes/a1", nil)
+	r.Header.Set("Origin", "http://anywhere.org")
+	w = httptest.NewRecorder()
+	NewCORSPolicy("*").Handler(getHandler(scenesHandler))(w, r)
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("Wildcard policy returned Access-Control-Allow-Origin %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+// Responses to requests without an Origin header can be cached by nginx and served to the
+// frontend, so they must carry the allow header (with *) or vary on the origin (with a list).
+func TestCORSWithoutOrigin(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	varies := func(w *httptest.ResponseRecorder) bool {
+		return slices.Contains(w.Header().Values("Vary"), "Origin")
+	}
+	cases := []struct {
+		origins, allow string
+		vary           bool
+	}{
+		{"*", "*", false},
+		{"http://corgiman.infty.nl", "http://corgiman.infty.nl", true},
+	}
+	for _, c := range cases {
+		handler := NewCORSPolicy(c.origins).Handler(getHandler(scenesHandler))
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/scenes/a1", nil))
+		if w.Code != http.StatusOK || varies(w) != c.vary {
+			t.Errorf("Policy %q without Origin returned %v with Vary %v", c.origins, w.Code, w.Header().Values("Vary"))
+		}
+		if c.origins == "*" && w.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("Policy %q without Origin returned no Access-Control-Allow-Origin", c.origins)
+		}
+		if c.origins != "*" && w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("Policy %q without Origin returned Access-Control-Allow-Origin %q", c.origins, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+
+		r := httptest.NewRequest("GET", "/scenes/a1", nil)
+		r.Header.Set("Origin", "http://corgiman.infty.nl")
+		w = httptest.NewRecorder()
+		handler(w, r)
+		if w.Header().Get("Access-Control-Allow-Origin") != c.allow || varies(w) != c.vary {
+			t.Errorf("Policy %q with Origin returned headers %v", c.origins, w.Header())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Errors of the API. Every error is returned with its HTTP status and a JSON body that holds the
+// status, a machine-readable code, a message for humans and the parameter that caused the error:
+//
+//	{"Error": {"Status": 400, "Code": "invalid_parameter", "Message": "...", "Param": "lat"}}
+//
+// Browsers don't run JSONP scripts of error responses, so JSONP responses always have status
+// 200 and pass the real status as the second argument of the callback, see jsonpHandler.
+package main
+
+import (
+	"net/http"
+)
+
+// The machine-readable error codes.
+const (
+	codeInvalidParameter = "invalid_parameter"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeStaleCursor      = "stale_cursor"
+	codeInternal         = "internal_error"
+)
+
+// An error of the API. Param is the query parameter that caused the error, if any.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Param   string `json:",omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+var (
+	errNotFound         = &APIError{http.StatusNotFound, codeNotFound, "resource not found", ""}
+	errMethodNotAllowed = &APIError{http.StatusMethodNotAllowed, codeMethodNotAllowed, "only GET and HEAD requests are allowed", ""}
+)
+
+// Returns the error of a query parameter with an invalid value.
+func invalidParam(param, message string) *APIError {
+	return &APIError{http.StatusBadRequest, codeInvalidParameter, message, param}
+}
+
+// Writes the error with its status. Errors that are not an *APIError are internal errors.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := err.(*APIError)
+	if !ok {
+		e = &APIError{http.StatusInternalServerError, codeInternal, err.Error(), ""}
+	}
+	setJSONType(w)
+	w.WriteHeader(e.Status)
+	writeResult(w, r, Error{e})
+}
+
+// Only allows GET and HEAD requests, the API doesn't change any data.
+func getHandler(fn Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		fn(w, r)
+	}
+}
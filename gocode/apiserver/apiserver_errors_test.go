@@ -0,0 +1,84 @@
+// Tests for apiserver_errors.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	cases := []struct {
+		method, path string
+		status       int
+		code, param  string
+	}{
+		{"GET", "/unknown", http.StatusNotFound, codeNotFound, ""},
+		{"GET", "/near?lat=x&lng=-122.42", http.StatusBadRequest, codeInvalidParameter, "lat"},
+		{"GET", "/near?lat=37.78", http.StatusBadRequest, codeInvalidParameter, "lng"},
+		{"GET", "/near?lat=NaN&lng=NaN", http.StatusBadRequest, codeInvalidParameter, "lat"},
+		{"GET", "/near?lat=37.78&lng=NaN", http.StatusBadRequest, codeInvalidParameter, "lng"},
+		{"GET", "/near?lat=Inf&lng=-122.42", http.StatusBadRequest, codeInvalidParameter, "lat"},
+		{"GET", "/near?lat=37.78&lng=-Inf", http.StatusBadRequest, codeInvalidParameter, "lng"},
+		{"GET", "/near?lat=90.5&lng=-122.42", http.StatusBadRequest, codeInvalidParameter, "lat"},
+		{"GET", "/near?lat=-91&lng=-122.42", http.StatusBadRequest, codeInvalidParameter, "lat"},
+		{"GET", "/near?lat=37.78&lng=180.1", http.StatusBadRequest, codeInvalidParameter, "lng"},
+		{"GET", "/near?lat=37.78&lng=-181", http.StatusBadRequest, codeInvalidParameter, "lng"},
+		{"GET", "/near?lat=37.78&lng=-122.42&limit=0", http.StatusBadRequest, codeInvalidParameter, "limit"},
+		{"GET", "/near?lat=37.78&lng=-122.42&cursor=x", http.StatusBadRequest, codeInvalidParameter, "cursor"},
+		{"GET", "/search?q=hall&fuzzy=9", http.StatusBadRequest, codeInvalidParameter, "fuzzy"},
+		{"GET", "/search?q=hall&op=xor", http.StatusBadRequest, codeInvalidParameter, "op"},
+		{"GET", "/search?q=hall&year_from=x", http.StatusBadRequest, codeInvalidParameter, "year_from"},
+		{"GET", "/search?q=", http.StatusBadRequest, codeInvalidParameter, "q"},
+		{"GET", "/within?minLat=37.8&minLng=-122.5&maxLat=37.7&maxLng=-122.4", http.StatusBadRequest, codeInvalidParameter, "minLat"},
+		{"GET", "/within?minLat=37.7&minLng=-122.5&maxLat=37.8", http.StatusBadRequest, codeInvalidParameter, "maxLng"},
+		{"GET", "/within?minLat=NaN&minLng=-122.5&maxLat=37.8&maxLng=-122.4", http.StatusBadRequest, codeInvalidParameter, "minLat"},
+		{"GET", "/within?minLat=37.7&minLng=-Inf&maxLat=37.8&maxLng=-122.4", http.StatusBadRequest, codeInvalidParameter, "minLng"},
+		{"GET", "/within?minLat=37.7&minLng=-122.5&maxLat=91&maxLng=-122.4", http.StatusBadRequest, codeInvalidParameter, "maxLat"},
+		{"GET", "/within?minLat=37.7&minLng=-122.5&maxLat=37.8&maxLng=NaN", http.StatusBadRequest, codeInvalidParameter, "maxLng"},
+		{"GET", "/within?minLat=37.7&minLng=-200&maxLat=37.8&maxLng=-122.4", http.StatusBadRequest, codeInvalidParameter, "minLng"},
+		{"POST", "/search?q=hall", http.StatusMethodNotAllowed, codeMethodNotAllowed, ""},
+	}
+	handler := getHandler(rootHandler)
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(c.method, c.path, nil))
+		var e Error
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Error == nil {
+			t.Errorf("%v %v returned %v, want an error", c.method, c.path, w.Body.String())
+			continue
+		}
+		if w.Code != c.status || e.Error.Status != c.status || e.Error.Code != c.code || e.Error.Param != c.param || e.Error.Message == "" {
+			t.Errorf("%v %v returned %v %+v, want %v %v for %q", c.method, c.path, w.Code, e.Error, c.status, c.code, c.param)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("DELETE", "/", nil))
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD" {
+		t.Errorf("405 response has Allow %q", allow)
+	}
+
+	w = httptest.NewRecorder()
+	writeError(w, httptest.NewRequest("GET", "/", nil), errors.New("broken"))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), codeInternal) {
+		t.Errorf("writeError returned %v %v for an internal error", w.Code, w.Body.String())
+	}
+}
+
+func TestEmptySearch(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	var res SearchResults
+	if code := getJSON(t, searchHandler, "/search?q=vertigo&fuzzy=0", &res); code != http.StatusOK {
+		t.Fatalf("Search without results returned %v", code)
+	}
+	if res.Movies == nil || res.Scenes == nil || len(res.Scenes) != 0 || res.Total != 0 {
+		t.Errorf("Search without results returned %+v", res)
+	}
+}
@@ -0,0 +1,159 @@
+// Bulk download of the whole data set. /export.csv and /export.ndjson stream every scene joined
+// with its movie, one row per scene, so analysts don't have to page through the API. The rows
+// are written while they are built from the in-memory APIData. The export only changes when
+// newer data is swapped in, so the responses carry an ETag and Last-Modified derived from
+// APIData.Time and conditional requests are answered with 304 Not Modified, see apiserver_cache.go.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// A scene joined with its movie. Unknown values are empty.
+type ExportRow struct {
+	SceneID    string
+	LocationID string
+	Location   string
+	Lat        float64
+	Lng        float64
+	IMDBID     string
+	Title      string
+	Year       string
+	Rated      string
+	Released   string // formatted as 2006-01-02
+	Runtime    int    // in minutes
+	Genres     []string
+	Directors  []string
+	Writers    []string
+	Actors     []string
+}
+
+// The columns of /export.csv, in the order of the ExportRow fields.
+var exportColumns = []string{
+	"SceneID", "LocationID", "Location", "Lat", "Lng", "IMDBID", "Title", "Year", "Rated",
+	"Released", "Runtime", "Genres", "Directors", "Writers", "Actors",
+}
+
+// Returns the row as CSV fields. Lists are joined with commas and unknown numbers are empty.
+func (row *ExportRow) record() []string {
+	var lat, lng, runtime string
+	if row.Location != "" {
+		lat = strconv.FormatFloat(row.Lat, 'f', -1, 64)
+		lng = strconv.FormatFloat(row.Lng, 'f', -1, 64)
+	}
+	if row.Runtime != 0 {
+		runtime = strconv.Itoa(row.Runtime)
+	}
+	return []string{
+		row.SceneID, row.LocationID, row.Location, lat, lng, row.IMDBID, row.Title, row.Year, row.Rated,
+		row.Released, runtime, strings.Join(row.Genres, ", "), strings.Join(row.Directors, ", "),
+		strings.Join(row.Writers, ", "), strings.Join(row.Actors, ", "),
+	}
+}
+
+// Calls fn with the row of every scene, ordered by movie, location name and scene ID.
+// Stops at the first error fn returns.
+func (st *apiState) visitExport(fn func(*ExportRow) error) error {
+	ids := make([]string, 0, len(st.Data.Scenes))
+	for id := range st.Data.Scenes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := st.Data.Scenes[ids[i]], st.Data.Scenes[ids[j]]
+		if a.IMDBID != b.IMDBID {
+			return a.IMDBID < b.IMDBID
+		}
+		if an, bn := sceneName(a), sceneName(b); an != bn {
+			return an < bn
+		}
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		scene := st.Data.Scenes[id]
+		row := &ExportRow{SceneID: id, IMDBID: scene.IMDBID}
+		if scene.Location != nil {
+			row.LocationID = LocationID(scene.Location)
+			row.Location = scene.Name
+			row.Lat = scene.Lat
+			row.Lng = scene.Lng
+		}
+		if movie, ok := st.Data.Movies[scene.IMDBID]; ok {
+			row.Title = movie.Title
+			if movie.Year != (sfmovies.YearRange{}) {
+				row.Year = movie.Year.String()
+			}
+			row.Rated = movie.Rated
+			if !movie.Released.IsZero() {
+				row.Released = movie.Released.Format("2006-01-02")
+			}
+			row.Runtime = movie.Runtime
+			row.Genres = movie.Genres
+			row.Directors = sfmovies.PersonStrings(movie.Directors)
+			row.Writers = sfmovies.PersonStrings(movie.Writers)
+			row.Actors = sfmovies.PersonStrings(movie.Actors)
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the location name of the scene, or "" if it has no location.
+func sceneName(scene *sfmovies.Scene) string {
+	if scene.Location == nil {
+		return ""
+	}
+	return scene.Name
+}
+
+// Sets the headers of an export in the format with the extension ext. Returns false if the
+// client already has the current version.
+func startExport(w http.ResponseWriter, r *http.Request, st *apiState, ext, contentType string) bool {
+	version := st.Data.Time
+	w.Header().Set("Cache-Control", *cacheControl)
+	// weak like the other ETags, the body is compressed or not depending on the client
+	if notModified(w, r, version, `W/"`+strconv.FormatInt(version.UnixNano(), 36)+"-"+ext+`"`) {
+		return false
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="sfmovies-`+version.UTC().Format("20060102")+"."+ext+`"`)
+	return true
+}
+
+// Streams every scene joined with its movie as CSV, with a header row.
+func exportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+	if !startExport(w, r, st, "csv", "text/csv; charset=utf-8") {
+		return
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(exportColumns)
+	st.visitExport(func(row *ExportRow) error {
+		return cw.Write(row.record())
+	})
+	cw.Flush()
+}
+
+// Streams every scene joined with its movie as newline delimited JSON, one ExportRow per line.
+func exportNDJSONHandler(w http.ResponseWriter, r *http.Request) {
+	st := state.Load()
+	if !startExport(w, r, st, "ndjson", "application/x-ndjson") {
+		return
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	st.visitExport(func(row *ExportRow) error {
+		return enc.Encode(row)
+	})
+	bw.Flush()
+}
@@ -0,0 +1,137 @@
+// Tests for apiserver_export.go
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Creates the resources test data with a full movie and a data version.
+func exportTestData() *sfmovies.APIData {
+	ad := resourcesTestData()
+	ad.Time = time.Date(2015, 3, 1, 4, 0, 0, 0, time.UTC)
+	ad.Movies["tt0066999"] = (&sfmovies.MovieView{
+		IMDBID:   "tt0066999",
+		Title:    "Dirty Harry",
+		Year:     "1971",
+		Rated:    "R",
+		Released: "23 Dec 1971",
+		Runtime:  "102 min",
+		Genre:    "Action, Crime, Thriller",
+		Director: "Don Siegel",
+		Writer:   "Harry Julian Fink (screenplay), Rita M. Fink (screenplay)",
+		Actors:   "Clint Eastwood, Harry Guardino",
+	}).Movie()
+	return ad
+}
+
+func TestExportCSV(t *testing.T) {
+	defer serveTestData(exportTestData())()
+
+	w := httptest.NewRecorder()
+	exportCSVHandler(w, httptest.NewRequest("GET", "/export.csv", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type is %q", ct)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || !reflect.DeepEqual(records[0], exportColumns) {
+		t.Fatalf("/export.csv returned %v", records)
+	}
+	want := []string{
+		"a1", LocationID(&sfmovies.Location{Lat: 37.7792597, Lng: -122.4192646}), "City Hall", "37.7792597", "-122.4192646",
+		"tt0066999", "Dirty Harry", "1971", "R", "1971-12-23", "102", "Action, Crime, Thriller", "Don Siegel",
+		"Harry Julian Fink (screenplay), Rita M. Fink (screenplay)", "Clint Eastwood, Harry Guardino",
+	}
+	if !reflect.DeepEqual(records[1], want) {
+		t.Errorf("First row is\n%q, want\n%q", records[1], want)
+	}
+	if ids := []string{records[2][0], records[3][0]}; !reflect.DeepEqual(ids, []string{"a2", "b1"}) {
+		t.Errorf("Rows are ordered %v", ids)
+	}
+	if runtime := records[3][10]; runtime != "" {
+		t.Errorf("Unknown runtime is %q, want an empty field", runtime)
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	defer serveTestData(exportTestData())()
+
+	w := httptest.NewRecorder()
+	exportNDJSONHandler(w, httptest.NewRequest("GET", "/export.ndjson", nil))
+	var rows []ExportRow
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var row ExportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("Line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("/export.ndjson returned %v rows, want %v", len(rows), 3)
+	}
+	if r := rows[1]; r.SceneID != "a2" || r.Location != "Kezar Stadium" || r.Lat != 37.7670 || r.Title != "Dirty Harry" || r.Runtime != 102 || len(r.Writers) != 2 {
+		t.Errorf("Second row is %+v", r)
+	}
+}
+
+func TestExportConditional(t *testing.T) {
+	ad := exportTestData()
+	defer serveTestData(ad)()
+
+	w := httptest.NewRecorder()
+	exportCSVHandler(w, httptest.NewRequest("GET", "/export.csv", nil))
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if !strings.HasPrefix(etag, `W/"`) || lastModified != "Sun, 01 Mar 2015 04:00:00 GMT" {
+		t.Fatalf("Got ETag %q and Last-Modified %q", etag, lastModified)
+	}
+
+	cases := []struct {
+		handler http.HandlerFunc
+		header  string
+		value   string
+		code    int
+	}{
+		{exportCSVHandler, "If-None-Match", etag, http.StatusNotModified},
+		{exportCSVHandler, "If-None-Match", `"other", ` + strings.TrimPrefix(etag, "W/"), http.StatusNotModified},
+		{exportCSVHandler, "If-None-Match", `"other"`, http.StatusOK},
+		{exportNDJSONHandler, "If-None-Match", etag, http.StatusOK},
+		{exportCSVHandler, "If-Modified-Since", lastModified, http.StatusNotModified},
+		{exportNDJSONHandler, "If-Modified-Since", "Sat, 28 Feb 2015 04:00:00 GMT", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/export", nil)
+		r.Header.Set(c.header, c.value)
+		w := httptest.NewRecorder()
+		c.handler(w, r)
+		if w.Code != c.code {
+			t.Errorf("%v: %v returned %v, want %v", c.header, c.value, w.Code, c.code)
+		}
+		if c.code == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%v: %v returned a body", c.header, c.value)
+		}
+	}
+
+	newer := exportTestData()
+	newer.Time = ad.Time.Add(24 * time.Hour)
+	swapAPIData(newer)
+	r := httptest.NewRequest("GET", "/export.csv", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	exportCSVHandler(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("Newer data returned %v with ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
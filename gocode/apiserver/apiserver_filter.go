@@ -0,0 +1,158 @@
+// Structured filters for search and near queries. The genres, rating and directors of
+// every movie are cleaned up once when the data is loaded so that filtering a scene is cheap.
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// The fields of a movie that can be filtered on.
+type movieFacets struct {
+	years     sfmovies.YearRange // zero if unknown
+	genres    []string           // cleaned up
+	rated     string             // cleaned up
+	directors []string           // cleaned up
+}
+
+// Collects the filterable fields of every movie.
+func newMovieFacets(ad *sfmovies.APIData) map[string]*movieFacets {
+	facets := make(map[string]*movieFacets)
+	for imdbid, movie := range ad.Movies {
+		f := &movieFacets{years: movie.Year}
+		for _, genre := range movie.Genres {
+			f.genres = append(f.genres, cleanPhrase(genre))
+		}
+		f.rated = cleanPhrase(movie.Rated)
+		for _, p := range movie.Directors {
+			f.directors = append(f.directors, cleanPhrase(p.Name))
+		}
+		facets[imdbid] = f
+	}
+	return facets
+}
+
+// Cleans up str and joins its words with single spaces.
+func cleanPhrase(str string) string {
+	return strings.Join(strings.Fields(CleanString(str)), " ")
+}
+
+// The filters of a query. The zero value matches every movie.
+type Filter struct {
+	Years    sfmovies.YearRange // From and To are zero if not set
+	Genres   []string           // the movie must have all genres
+	Rated    string
+	Director string // matches if it is (part of) the name of one of the directors
+	IMDBID   string
+}
+
+// Parses the year_from, year_to, genre, rated, director and imdb_id parameters.
+// Multiple genres are separated by commas.
+func ParseFilter(r *http.Request) (*Filter, error) {
+	f := new(Filter)
+	var err error
+	if v := r.FormValue("year_from"); v != "" {
+		if f.Years.From, err = strconv.Atoi(v); err != nil {
+			return nil, invalidParam("year_from", "year_from must be a year")
+		}
+	}
+	if v := r.FormValue("year_to"); v != "" {
+		if f.Years.To, err = strconv.Atoi(v); err != nil {
+			return nil, invalidParam("year_to", "year_to must be a year")
+		}
+	}
+	if f.Years.To != 0 && f.Years.To < f.Years.From {
+		return nil, invalidParam("year_to", "year_to must not be before year_from")
+	}
+	for _, genre := range strings.Split(r.FormValue("genre"), ",") {
+		if g := cleanPhrase(genre); g != "" {
+			f.Genres = append(f.Genres, g)
+		}
+	}
+	f.Rated = cleanPhrase(r.FormValue("rated"))
+	f.Director = cleanPhrase(r.FormValue("director"))
+	f.IMDBID = strings.TrimSpace(r.FormValue("imdb_id"))
+	return f, nil
+}
+
+// Checks if no filters are set.
+func (f *Filter) IsEmpty() bool {
+	return f.Years == sfmovies.YearRange{} && len(f.Genres) == 0 && f.Rated == "" && f.Director == "" && f.IMDBID == ""
+}
+
+// Checks if the movie with the facets passes the filters.
+func (f *Filter) Matches(imdbid string, mf *movieFacets) bool {
+	if f.IMDBID != "" && f.IMDBID != imdbid {
+		return false
+	}
+	if f.IsEmpty() {
+		return true
+	}
+	if mf == nil {
+		return false
+	}
+	if f.Years != (sfmovies.YearRange{}) && (mf.years.From == 0 || !mf.years.Overlaps(f.Years)) {
+		return false
+	}
+	for _, genre := range f.Genres {
+		if !contains(mf.genres, genre) {
+			return false
+		}
+	}
+	if f.Rated != "" && f.Rated != mf.rated {
+		return false
+	}
+	if f.Director != "" {
+		found := false
+		for _, name := range mf.directors {
+			found = found || strings.Contains(" "+name+" ", " "+f.Director+" ")
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a function that reports whether a scene passes the filters, or nil if no filters are set.
+func (f *Filter) sceneFilter(facets map[string]*movieFacets) func(*sfmovies.Scene) bool {
+	if f.IsEmpty() {
+		return nil
+	}
+	return func(scene *sfmovies.Scene) bool {
+		return f.Matches(scene.IMDBID, facets[scene.IMDBID])
+	}
+}
+
+func contains(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the scenes and movies that don't pass the filters.
+func (r *SearchResults) filter(f *Filter, facets map[string]*movieFacets) {
+	if f.IsEmpty() {
+		return
+	}
+	scenes := r.Scenes[:0]
+	for _, scene := range r.Scenes {
+		if f.Matches(scene.IMDBID, facets[scene.IMDBID]) {
+			scenes = append(scenes, scene)
+		}
+	}
+	r.Scenes = scenes
+	movies := r.Movies[:0]
+	for _, movie := range r.Movies {
+		if f.Matches(movie.IMDBID, facets[movie.IMDBID]) {
+			movies = append(movies, movie)
+		}
+	}
+	r.Movies = movies
+}
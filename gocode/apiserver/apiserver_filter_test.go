@@ -0,0 +1,140 @@
+// Tests for apiserver_filter.go
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Creates APIData with a few movies that differ in year, genre, rating and director.
+func filterTestData() *sfmovies.APIData {
+	ad := sfmovies.NewAPIData()
+	views := []*sfmovies.MovieView{
+		{IMDBID: "tt0052357", Title: "Vertigo", Year: "1958", Rated: "PG", Genre: "Mystery, Romance, Thriller", Director: "Alfred Hitchcock"},
+		{IMDBID: "tt0066999", Title: "Dirty Harry", Year: "1971", Rated: "R", Genre: "Action, Crime, Thriller", Director: "Don Siegel"},
+		{IMDBID: "tt1307068", Title: "Sense8", Year: "2015–2018", Rated: "TV-MA", Genre: "Drama, Mystery, Sci-Fi", Director: "Lana Wachowski, Lilly Wachowski"},
+		{IMDBID: "tt0000001", Title: "Unknown", Year: "N/A", Rated: "N/A", Genre: "N/A", Director: "N/A"},
+	}
+	for i, view := range views {
+		movie := view.Movie()
+		ad.Movies[movie.IMDBID] = movie
+		ad.Scenes[movie.IMDBID] = &sfmovies.Scene{
+			IMDBID:   movie.IMDBID,
+			Location: &sfmovies.Location{Name: "San Francisco", Lat: 37.78 + float64(i)/100, Lng: -122.42},
+		}
+	}
+	return ad
+}
+
+func TestFilterMatches(t *testing.T) {
+	facets := newMovieFacets(filterTestData())
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"tt0000001", "tt0052357", "tt0066999", "tt1307068"}},
+		{"year_from=1960", []string{"tt0066999", "tt1307068"}},
+		{"year_to=1960", []string{"tt0052357"}},
+		{"year_from=1958&year_to=1971", []string{"tt0052357", "tt0066999"}},
+		{"year_from=2017&year_to=2020", []string{"tt1307068"}},
+		{"genre=thriller", []string{"tt0052357", "tt0066999"}},
+		{"genre=Thriller,+crime", []string{"tt0066999"}},
+		{"genre=sci-fi", []string{"tt1307068"}},
+		{"rated=pg", []string{"tt0052357"}},
+		{"rated=tv-ma", []string{"tt1307068"}},
+		{"director=hitchcock", []string{"tt0052357"}},
+		{"director=lilly+wachowski", []string{"tt1307068"}},
+		{"director=wachowski+lilly", []string{}},
+		{"director=hitch", []string{}},
+		{"imdb_id=tt0066999", []string{"tt0066999"}},
+		{"imdb_id=tt0066999&rated=pg", []string{}},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(httptest.NewRequest("GET", "/search?"+c.query, nil))
+		if err != nil {
+			t.Errorf("ParseFilter(%q) returned %v", c.query, err)
+			continue
+		}
+		got := []string{}
+		for _, imdbid := range []string{"tt0000001", "tt0052357", "tt0066999", "tt1307068"} {
+			if f.Matches(imdbid, facets[imdbid]) {
+				got = append(got, imdbid)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Filter %q matches %v, want %v", c.query, got, c.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	cases := []string{
+		"year_from=fifties",
+		"year_to=1960s",
+		"year_from=1980&year_to=1970",
+	}
+	for _, c := range cases {
+		if _, err := ParseFilter(httptest.NewRequest("GET", "/search?"+c, nil)); err == nil {
+			t.Errorf("ParseFilter(%q) did not return an error", c)
+		}
+	}
+}
+
+func TestFilteredHandlers(t *testing.T) {
+	defer serveTestData(filterTestData())()
+
+	w := httptest.NewRecorder()
+	searchHandler(w, httptest.NewRequest("GET", "/search?q=san+francisco&op=or&genre=thriller&year_from=1960", nil))
+	var res SearchResults
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Scenes) != 1 || res.Scenes[0].IMDBID != "tt0066999" {
+		t.Errorf("Filtered search returned %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	nearHandler(w, httptest.NewRequest("GET", "/near?lat=37.78&lng=-122.42&rated=tv-ma", nil))
+	var near NearResults
+	if err := json.Unmarshal(w.Body.Bytes(), &near); err != nil {
+		t.Fatal(err)
+	}
+	if len(near.Scenes) != 1 || near.Scenes[0].IMDBID != "tt1307068" || near.Total != 1 {
+		t.Errorf("Filtered near returned %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	searchHandler(w, httptest.NewRequest("GET", "/search?q=san+francisco&year_to=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Malformed filter returned %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestNearestFilter(t *testing.T) {
+	scenes := randomScenes(1000, 1)
+	tree := NewKDTree(scenes)
+	even := func(scene *sfmovies.Scene) bool { return scene.IMDBID[len(scene.IMDBID)-1]%2 == 0 }
+	var accepted []*sfmovies.Scene
+	for _, scene := range scenes {
+		if even(scene) {
+			accepted = append(accepted, scene)
+		}
+	}
+	loc := &sfmovies.Location{Lat: 37.77, Lng: -122.42}
+	got := tree.Nearest(loc, 10, math.Inf(1), even)
+	want := NewKDTree(accepted).Nearest(loc, 10, math.Inf(1), nil)
+	if len(got) != len(want) {
+		t.Fatalf("Got %v scenes, want %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Scene != want[i].Scene {
+			t.Errorf("Scene %v is %v, want %v", i, got[i].IMDBID, want[i].IMDBID)
+		}
+	}
+}
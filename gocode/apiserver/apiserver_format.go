@@ -0,0 +1,89 @@
+// Output formats of the endpoints that return scenes. Scenes are returned as JSON by default.
+// Clients that want to put them on a map can ask for GeoJSON, KML (Google Earth) or GPX (GPS
+// and hiking apps) with the format parameter, e.g. format=kml, or with the Accept header.
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	formatJSON    = "json"
+	formatGeoJSON = "geojson"
+	formatKML     = "kml"
+	formatGPX     = "gpx"
+)
+
+// The media types of the formats, in the order they are matched against the Accept header.
+var formatTypes = []struct{ format, mediaType string }{
+	{formatGeoJSON, geoJSONType},
+	{formatKML, kmlType},
+	{formatGPX, gpxType},
+}
+
+var errBadFormat = invalidParam("format", "format must be json, geojson, kml or gpx")
+
+// Results that can be written as GeoJSON, KML or GPX.
+type featureList interface {
+	features(st *apiState) *FeatureCollection
+}
+
+// Returns the format the client asked for, the format parameter takes precedence over the
+// Accept header. Adds Vary: Accept since the response depends on it.
+func parseFormat(w http.ResponseWriter, r *http.Request) (string, error) {
+	addVary(w.Header(), "Accept")
+	return requestFormat(r)
+}
+
+// Returns the format the client asked for without touching the response.
+func requestFormat(r *http.Request) (string, error) {
+	switch f := r.FormValue("format"); f {
+	case "":
+	case formatJSON, formatGeoJSON, formatKML, formatGPX:
+		return f, nil
+	default:
+		return "", errBadFormat
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, t := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(t, ";")
+			for _, ft := range formatTypes {
+				if strings.TrimSpace(mediaType) == ft.mediaType {
+					return ft.format, nil
+				}
+			}
+		}
+	}
+	return formatJSON, nil
+}
+
+// Writes result in the format the client asked for.
+func writeScenes(w http.ResponseWriter, r *http.Request, st *apiState, result featureList) {
+	format, err := parseFormat(w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if format == formatJSON {
+		writeResult(w, r, result)
+		return
+	}
+	writeFeatures(w, r, format, result.features(st))
+}
+
+// Writes the feature collection as GeoJSON, KML or GPX. KML and GPX have no place for the
+// pagination fields, so the link to the next page is also sent in a Link header.
+func writeFeatures(w http.ResponseWriter, r *http.Request, format string, fc *FeatureCollection) {
+	if fc.Next != "" {
+		w.Header().Set("Link", "<"+fc.Next+`>; rel="next"`)
+	}
+	switch format {
+	case formatGeoJSON:
+		writeGeoJSON(w, r, fc)
+	case formatKML:
+		writeKML(w, fc)
+	case formatGPX:
+		writeGPX(w, fc)
+	}
+}
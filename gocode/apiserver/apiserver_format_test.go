@@ -0,0 +1,47 @@
+// Tests for apiserver_format.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	cases := []struct {
+		query, accept string
+		out           string
+	}{
+		{"", "", formatJSON},
+		{"", "application/json", formatJSON},
+		{"format=geojson", "", formatGeoJSON},
+		{"format=json", geoJSONType, formatJSON},
+		{"format=kml", "", formatKML},
+		{"format=gpx", "", formatGPX},
+		{"", "application/geo+json", formatGeoJSON},
+		{"", "text/html, application/geo+json;q=0.9, */*;q=0.1", formatGeoJSON},
+		{"", "application/geo+json-seq", formatJSON},
+		{"", "application/vnd.google-earth.kml+xml", formatKML},
+		{"", "application/gpx+xml, application/xml;q=0.5", formatGPX},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/near?"+c.query, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		if got, err := parseFormat(w, r); got != c.out || err != nil {
+			t.Errorf("parseFormat(%q, Accept: %q) == %q, %v, want %q", c.query, c.accept, got, err, c.out)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("parseFormat did not set Vary: Accept")
+		}
+	}
+
+	defer serveTestData(resourcesTestData())()
+	w := httptest.NewRecorder()
+	nearHandler(w, httptest.NewRequest("GET", "/near?lat=37.78&lng=-122.42&format=shapefile", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unknown format returned %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
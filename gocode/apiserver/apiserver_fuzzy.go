@@ -0,0 +1,79 @@
+// Typo tolerant lookups in the trie. The trie is traversed depth first while a row of the
+// Damerau-Levenshtein (optimal string alignment) matrix is computed for every node. A branch is
+// abandoned as soon as every value in the row exceeds the maximum edit distance, so only a small
+// part of the trie is visited.
+package main
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// A node of the trie and the edit distance between the word leading to it and the queried word.
+type fuzzyMatch struct {
+	node  *TrieNode
+	dist  int
+	depth int
+}
+
+// The number of edits allowed for a word. Short words allow fewer edits, otherwise almost
+// every short word in the trie would match: one edit from 4 letters on, two from 8 letters on.
+func allowedEdits(word string, max int) int {
+	return min(max, utf8.RuneCountInString(word)/4)
+}
+
+// Returns the nodes of the words within max edits of word, closest first. If prefix is set
+// the nodes of the prefixes within max edits of word are returned instead, so that every word
+// below such a node starts with a string that is close to word. Of the prefixes that are
+// equally close the longest come first.
+func (t *TrieNode) FuzzyFind(word string, max int, prefix bool) []fuzzyMatch {
+	r := make([]fuzzyMatch, 0)
+	letters := []rune(word)
+	row := make([]int, len(letters)+1)
+	for i := range row {
+		row[i] = i
+	}
+	for _, n := range t.next {
+		n.fuzzyWalk(letters, max, prefix, row, nil, 0, 1, &r)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		if r[i].dist != r[j].dist {
+			return r[i].dist < r[j].dist
+		}
+		return r[i].depth > r[j].depth
+	})
+	return r
+}
+
+// Computes the row of node n at the given depth from the rows of its parent and grandparent.
+func (n *TrieNode) fuzzyWalk(word []rune, max int, prefix bool, prev, prevPrev []int, prevLetter rune, depth int, r *[]fuzzyMatch) {
+	c := n.letter
+	row := make([]int, len(word)+1)
+	row[0] = prev[0] + 1
+	rowMin := row[0]
+	for i := 1; i <= len(word); i++ {
+		cost := 1
+		if word[i-1] == c {
+			cost = 0
+		}
+		row[i] = min(row[i-1]+1, prev[i]+1, prev[i-1]+cost)
+		// transposition of two adjacent letters
+		if prevPrev != nil && i > 1 && word[i-1] == prevLetter && word[i-2] == c {
+			row[i] = min(row[i], prevPrev[i-2]+1)
+		}
+		rowMin = min(rowMin, row[i])
+	}
+
+	d := row[len(word)]
+	if d <= max && (prefix || len(n.scenes) > 0) {
+		*r = append(*r, fuzzyMatch{n, d, depth})
+	}
+	// every word below an exact prefix is already covered by this node
+	if rowMin > max || prefix && d == 0 {
+		return
+	}
+
+	for _, m := range n.next {
+		m.fuzzyWalk(word, max, prefix, row, prev, c, depth+1, r)
+	}
+}
@@ -0,0 +1,153 @@
+// Tests for apiserver_fuzzy.go
+package main
+
+import (
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Optimal string alignment distance computed with the full matrix. Serves as reference.
+func osaDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func TestAllowedEdits(t *testing.T) {
+	cases := []struct {
+		word     string
+		max, out int
+	}{
+		{"", 2, 0},
+		{"abc", 2, 0},
+		{"adam", 2, 1},
+		{"sandlr", 2, 1},
+		{"fransisco", 2, 2},
+		{"fransisco", 1, 1},
+		{"fransisco", 0, 0},
+	}
+	for _, c := range cases {
+		got := allowedEdits(c.word, c.max)
+		if got != c.out {
+			t.Errorf("allowedEdits(%q, %v) == %v, want %v", c.word, c.max, got, c.out)
+		}
+	}
+}
+
+func TestTrieNodeFuzzyFind(t *testing.T) {
+	words := []string{"francisco", "franciscan", "france", "sandler", "sander", "sand", "hitchcock", "abc", "acb", "ab"}
+	n := NewTrieNode()
+	for _, w := range words {
+		n.Add(w, &sfmovies.Scene{})
+	}
+
+	queries := []string{"fransisco", "sandlr", "hitchcok", "bac", "a", "", "xyz", "francisco"}
+	for _, q := range queries {
+		for max := 0; max <= 2; max++ {
+			want := make(map[string]int)
+			for _, w := range words {
+				if d := osaDistance(q, w); d <= max {
+					want[w] = d
+				}
+			}
+			got := n.FuzzyFind(q, max, false)
+			if len(got) != len(want) {
+				t.Errorf("FuzzyFind(%q, %v) found %v words, want %v", q, max, len(got), len(want))
+			}
+			for i, m := range got {
+				w := m.node.String()
+				if d, ok := want[w]; !ok || d != m.dist {
+					t.Errorf("FuzzyFind(%q, %v) found %q at distance %v, want %v", q, max, w, m.dist, want[w])
+				}
+				if i > 0 && got[i-1].dist > m.dist {
+					t.Errorf("FuzzyFind(%q, %v) is not ordered by distance", q, max)
+				}
+			}
+		}
+	}
+
+	// Prefix matches
+	cases := []struct {
+		q    string
+		max  int
+		out  string
+		dist int
+	}{
+		{"fran", 0, "fran", 0},
+		{"frn", 1, "fran", 1},
+		{"sandl", 1, "sandl", 0},
+		{"snadl", 1, "sandl", 1},
+		{"hitchk", 1, "hitchc", 1},
+		{"hitchcockk", 1, "hitchcock", 1},
+	}
+	for _, c := range cases {
+		got := n.FuzzyFind(c.q, c.max, true)
+		if len(got) == 0 || got[0].node.String() != c.out || got[0].dist != c.dist {
+			t.Errorf("FuzzyFind(%q, %v, true)[0] == %v, want %q at distance %v", c.q, c.max, got, c.out, c.dist)
+		}
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = (&sfmovies.MovieView{IMDBID: "tt1", Title: "Bulletproof", Actors: "Adam Sandler"}).Movie()
+	ad.Movies["tt2"] = &sfmovies.Movie{IMDBID: "tt2", Title: "Sandlot"}
+	sandler := &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "San Francisco"}}
+	sandlot := &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "Market Street"}}
+	ad.Scenes["1"], ad.Scenes["2"] = sandler, sandlot
+	n := CreateTrie(ad)
+
+	if got := n.Get(ad, "fransisco", true, 0); got != nil {
+		t.Errorf("Get(%q) without typo correction found %v scenes", "fransisco", len(got.Scenes))
+	}
+	got := n.Get(ad, "fransisco", true, 2)
+	if got == nil || len(got.Scenes) != 1 || got.Scenes[0].Scene != sandler || got.Scenes[0].EditDistance != 1 {
+		t.Errorf("Get(%q) did not find %v", "fransisco", sandler.Name)
+	}
+
+	// Exact matches come first, even if the fuzzy match has a higher score
+	got = n.Get(ad, "sandlot", true, 2)
+	if got == nil || len(got.Scenes) != 1 || got.Scenes[0].Scene != sandlot {
+		t.Errorf("Get(%q) == %v, want only %v", "sandlot", got, sandlot.Name)
+	}
+	got = n.Get(ad, "sandler", true, 2)
+	if got == nil || len(got.Scenes) != 1 {
+		t.Fatalf("Get(%q) == %v, want 1 scene", "sandler", got)
+	}
+	got = n.Get(ad, "sandle", true, 2)
+	if got == nil || len(got.Scenes) != 1 || got.Scenes[0].EditDistance != 1 {
+		t.Errorf("Get(%q) == %v, want 1 scene at distance 1", "sandle", got)
+	}
+	got = n.Get(ad, "sandlor", true, 2)
+	if got == nil || len(got.Scenes) != 2 {
+		t.Fatalf("Get(%q) == %v, want 2 scenes", "sandlor", got)
+	}
+
+	completions := n.Complete("sandlr", 10, 2)
+	if len(completions) == 0 || completions[0].Text != "Adam Sandler" {
+		t.Errorf("Complete(%q) == %v, want %v first", "sandlr", completions, "Adam Sandler")
+	}
+	completions = n.Complete("sandl", 10, 2)
+	if len(completions) != 2 || completions[0].Text != "Adam Sandler" && completions[0].Text != "Sandlot" {
+		t.Errorf("Complete(%q) == %v, want exact matches first", "sandl", completions)
+	}
+}
@@ -0,0 +1,114 @@
+// GeoJSON (RFC 7946) output for the endpoints that return scenes, so the results can be loaded
+// into mapping libraries like Leaflet and Mapbox as they are. A request gets GeoJSON if it
+// sends "Accept: application/geo+json" or the format=geojson parameter, see apiserver_format.go.
+// Every scene becomes a point feature with the location name and the movie as its properties.
+// Lists of scenes become a feature collection that also holds the pagination fields.
+package main
+
+import (
+	"net/http"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+const geoJSONType = "application/geo+json"
+
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+	Page
+}
+
+type Feature struct {
+	Type       string             `json:"type"`
+	ID         string             `json:"id,omitempty"`
+	Geometry   *Geometry          `json:"geometry"`
+	Properties *FeatureProperties `json:"properties"`
+}
+
+type Geometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+// The properties of a scene feature: the location, the movie and, depending on the
+// endpoint, the distance to the queried location or the search score.
+type FeatureProperties struct {
+	IMDBID string
+	Name   string
+	*sfmovies.MovieView
+	LocationID     string   `json:",omitempty"`
+	DistanceMeters *float64 `json:",omitempty"`
+	Score          *float64 `json:",omitempty"`
+	EditDistance   int      `json:",omitempty"`
+}
+
+// Writes v as GeoJSON. JSONP responses keep their content type.
+func writeGeoJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", geoJSONType)
+	}
+	writeResult(w, r, v)
+}
+
+// Returns the feature of a scene with the movie as its properties.
+func (st *apiState) sceneFeature(scene *sfmovies.Scene) *Feature {
+	f := &Feature{
+		Type:       "Feature",
+		Properties: &FeatureProperties{IMDBID: scene.IMDBID},
+	}
+	if movie, ok := st.Data.Movies[scene.IMDBID]; ok {
+		f.Properties.MovieView = movie.View()
+	}
+	if scene.Location != nil {
+		f.Geometry = &Geometry{"Point", [2]float64{scene.Lng, scene.Lat}}
+		f.Properties.Name = scene.Name
+	}
+	if info := st.Resources.SceneInfo(scene); info != nil {
+		f.ID = info.ID
+		f.Properties.LocationID = info.LocationID
+	}
+	return f
+}
+
+// Returns a feature collection holding the features and the page.
+func newFeatureCollection(features []*Feature, page Page) *FeatureCollection {
+	return &FeatureCollection{"FeatureCollection", features, page}
+}
+
+func (r *SearchResults) features(st *apiState) *FeatureCollection {
+	features := make([]*Feature, len(r.Scenes))
+	for i, scene := range r.Scenes {
+		features[i] = st.sceneFeature(scene.Scene)
+		score := scene.Score
+		features[i].Properties.Score = &score
+		features[i].Properties.EditDistance = scene.EditDistance
+	}
+	return newFeatureCollection(features, r.Page)
+}
+
+func (r *NearResults) features(st *apiState) *FeatureCollection {
+	features := make([]*Feature, len(r.Scenes))
+	for i, scene := range r.Scenes {
+		features[i] = st.sceneFeature(scene.Scene)
+		d := scene.DistanceMeters
+		features[i].Properties.DistanceMeters = &d
+	}
+	return newFeatureCollection(features, r.Page)
+}
+
+func (r *WithinResults) features(st *apiState) *FeatureCollection {
+	features := make([]*Feature, len(r.Scenes))
+	for i, scene := range r.Scenes {
+		features[i] = st.sceneFeature(scene)
+	}
+	return newFeatureCollection(features, r.Page)
+}
+
+func (r *SceneResults) features(st *apiState) *FeatureCollection {
+	features := make([]*Feature, len(r.Scenes))
+	for i, scene := range r.Scenes {
+		features[i] = st.sceneFeature(scene.Scene)
+	}
+	return newFeatureCollection(features, r.Page)
+}
@@ -0,0 +1,77 @@
+// Tests for apiserver_geojson.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGeoJSONHandlers(t *testing.T) {
+	ad := resourcesTestData()
+	defer serveTestData(ad)()
+
+	cases := []struct {
+		handler http.HandlerFunc
+		path    string
+		n       int
+	}{
+		{nearHandler, "/near?lat=37.78&lng=-122.42&format=geojson", 3},
+		{searchHandler, "/search?q=hall&format=geojson", 2},
+		{withinHandler, "/within?minLat=37.7&minLng=-122.5&maxLat=37.8&maxLng=-122.4&format=geojson", 3},
+		{moviesHandler, "/movies/tt0066999/scenes?format=geojson", 2},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest("GET", c.path, nil))
+		if ct := w.Header().Get("Content-Type"); ct != geoJSONType {
+			t.Errorf("%v returned Content-Type %q, want %q", c.path, ct, geoJSONType)
+		}
+		var fc FeatureCollection
+		if err := json.Unmarshal(w.Body.Bytes(), &fc); err != nil {
+			t.Fatal(err)
+		}
+		if fc.Type != "FeatureCollection" || len(fc.Features) != c.n || fc.Total != c.n {
+			t.Errorf("%v returned %v", c.path, w.Body.String())
+			continue
+		}
+		for _, f := range fc.Features {
+			if f.Type != "Feature" || f.ID == "" || f.Geometry == nil || f.Geometry.Type != "Point" {
+				t.Errorf("%v returned feature %+v", c.path, f)
+				continue
+			}
+			scene := ad.Scenes[f.ID]
+			if f.Geometry.Coordinates != [2]float64{scene.Lng, scene.Lat} {
+				t.Errorf("%v returned coordinates %v for %v", c.path, f.Geometry.Coordinates, scene.Location)
+			}
+			if p := f.Properties; p.Name != scene.Name || p.IMDBID != scene.IMDBID || p.MovieView == nil || p.Title != ad.Movies[scene.IMDBID].Title {
+				t.Errorf("%v returned properties %+v for %v", c.path, p, scene.Location)
+			}
+		}
+	}
+
+	// near and search results carry their distance and score
+	w := httptest.NewRecorder()
+	nearHandler(w, httptest.NewRequest("GET", "/near?lat=37.7670&lng=-122.4563&format=geojson", nil))
+	var fc FeatureCollection
+	if err := json.Unmarshal(w.Body.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	if p := fc.Features[0].Properties; p.DistanceMeters == nil || *p.DistanceMeters != 0 || p.Score != nil {
+		t.Errorf("Closest feature has properties %+v, want distance 0", p)
+	}
+
+	// a single scene is a feature
+	r := httptest.NewRequest("GET", "/scenes/a1", nil)
+	r.Header.Set("Accept", geoJSONType)
+	w = httptest.NewRecorder()
+	scenesHandler(w, r)
+	var f Feature
+	if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != "Feature" || f.ID != "a1" || f.Properties.Title != "Dirty Harry" || f.Properties.LocationID == "" {
+		t.Errorf("/scenes/a1 returned %v", w.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+// JSONP support. JSONP predates CORS and is kept for older clients, new clients should use
+// plain JSON with CORS, see apiserver_cors.go. The callback parameter ends up as code in the
+// response, so it must be a plain JavaScript identifier or a dotted path of identifiers, e.g.
+// "jQuery19106_1425182400" or "app.show". Anything else is rejected to prevent reflected XSS.
+// JSONP can be turned off with --jsonp=false.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var jsonpEnabled = flag.Bool("jsonp", true, "serve JSONP if the callback parameter is set")
+
+// The maximum length of a callback name.
+const maxCallbackLength = 128
+
+var callbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// Words that can't be used as an identifier in JavaScript.
+var reservedWords = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true, "const": true,
+	"continue": true, "debugger": true, "default": true, "delete": true, "do": true, "else": true,
+	"enum": true, "export": true, "extends": true, "false": true, "finally": true, "for": true,
+	"function": true, "if": true, "implements": true, "import": true, "in": true, "instanceof": true,
+	"interface": true, "let": true, "new": true, "null": true, "package": true, "private": true,
+	"protected": true, "public": true, "return": true, "static": true, "super": true, "switch": true,
+	"this": true, "throw": true, "true": true, "try": true, "typeof": true, "var": true, "void": true,
+	"while": true, "with": true, "yield": true,
+}
+
+// Checks if the callback is an identifier or a dotted path of identifiers.
+func validCallback(callback string) bool {
+	if len(callback) > maxCallbackLength || !callbackPattern.MatchString(callback) {
+		return false
+	}
+	for _, name := range strings.Split(callback, ".") {
+		if reservedWords[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// Wraps around all other handlers and adds JSONP padding only if the callback parameter is set.
+// The status of the response is passed as the second argument of the callback, e.g.
+// callback({"Error": ...}, 404), since browsers don't run scripts of error responses.
+// The padding starts with an empty comment so the response can't be mistaken for a Flash file.
+// Only JSON can be padded, requests for KML or GPX with a callback are rejected.
+func jsonpHandler(fn Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		callback := r.FormValue("callback")
+		if callback == "" {
+			fn(w, r)
+			return
+		}
+		if !*jsonpEnabled {
+			writeError(w, r, invalidParam("callback", "JSONP is disabled, request JSON with CORS instead"))
+			return
+		}
+		if !validCallback(callback) {
+			writeError(w, r, invalidParam("callback", "callback must be a JavaScript identifier or a dotted path of identifiers"))
+			return
+		}
+		// KML and GPX are XML, padding them doesn't make a script
+		if f, err := requestFormat(r); err == nil && f != formatJSON && f != formatGeoJSON {
+			writeError(w, r, invalidParam("callback", "JSONP is only available for JSON and GeoJSON, request KML and GPX without a callback"))
+			return
+		}
+		// the result can be JSON or GeoJSON depending on the Accept header
+		addVary(w.Header(), "Accept")
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		jw := &jsonpWriter{ResponseWriter: w, callback: callback, status: http.StatusOK}
+		fn(jw, r)
+		jw.start()
+		_, err := w.Write([]byte(", " + strconv.Itoa(jw.status) + ");"))
+		if jw.err != nil || err != nil {
+			http.Error(w, "failed to write JSONP padding", http.StatusInternalServerError)
+		}
+	}
+}
+
+// Records the status of a JSONP response instead of sending it. The padding is written when fn
+// writes the status or the first part of the body, so the writers around it can be told the
+// status before the headers are sent, see statusReporter.
+type jsonpWriter struct {
+	http.ResponseWriter
+	callback string
+	status   int
+	started  bool
+	err      error
+}
+
+// Reports the status and writes the start of the padding.
+func (w *jsonpWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if sr, ok := w.ResponseWriter.(statusReporter); ok {
+		sr.ReportStatus(w.status)
+	}
+	_, w.err = w.ResponseWriter.Write([]byte("/**/" + w.callback + "("))
+}
+
+func (w *jsonpWriter) WriteHeader(code int) {
+	if !w.started {
+		w.status = code
+		w.start()
+	}
+}
+
+func (w *jsonpWriter) Write(p []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(p)
+}
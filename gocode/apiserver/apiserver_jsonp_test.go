@@ -0,0 +1,123 @@
+// Tests for apiserver_jsonp.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidCallback(t *testing.T) {
+	valid := []string{"cb", "jQuery19106796541290_1425182400", "$", "_cb1", "app.show", "window.$.cb"}
+	invalid := []string{
+		"", "1cb", "alert(1)", "cb;alert(1)", "cb//", "a..b", ".cb", "cb.", "a b", "<script>",
+		"cb\u0028", "new", "app.delete", "ünïcode", strings.Repeat("a", maxCallbackLength+1),
+	}
+	for _, c := range valid {
+		if !validCallback(c) {
+			t.Errorf("validCallback(%q) == false, want true", c)
+		}
+	}
+	for _, c := range invalid {
+		if validCallback(c) {
+			t.Errorf("validCallback(%q) == true, want false", c)
+		}
+	}
+}
+
+func TestJSONPCallbackRejected(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	handler := jsonpHandler(getHandler(scenesHandler))
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/scenes/a1?callback=alert(document.cookie)//", nil))
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "alert(document.cookie)//(") {
+		t.Errorf("Invalid callback returned %v %v", w.Code, w.Body.String())
+	}
+
+	defer func(enabled bool) { *jsonpEnabled = enabled }(*jsonpEnabled)
+	*jsonpEnabled = false
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/scenes/a1?callback=cb", nil))
+	if w.Code != http.StatusBadRequest || strings.HasPrefix(w.Body.String(), "/**/cb(") {
+		t.Errorf("Disabled JSONP returned %v %v", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/scenes/a1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("JSON request returned %v while JSONP is disabled", w.Code)
+	}
+}
+
+func TestJSONPStatus(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	cases := []struct {
+		path, suffix string
+	}{
+		{"/scenes/a1?callback=cb", ", 200);"},
+		{"/scenes/x?callback=cb", ", 404);"},
+		{"/near?lat=x&callback=cb", ", 400);"},
+	}
+	handler := jsonpHandler(getHandler(scenesHandler))
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(c.path, "/near") {
+			jsonpHandler(getHandler(rootHandler))(w, httptest.NewRequest("GET", c.path, nil))
+		} else {
+			handler(w, httptest.NewRequest("GET", c.path, nil))
+		}
+		body := w.Body.String()
+		if w.Code != http.StatusOK || !strings.HasPrefix(body, "/**/cb(") || !strings.HasSuffix(body, c.suffix) {
+			t.Errorf("%v returned %v %v, want status 200 and a payload ending in %q", c.path, w.Code, body, c.suffix)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/javascript" {
+			t.Errorf("%v returned Content-Type %q", c.path, ct)
+		}
+	}
+}
+
+func TestJSONPFormats(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	handler := jsonpHandler(getHandler(scenesHandler))
+	cases := []struct {
+		path, accept string
+		code         int
+	}{
+		{"/scenes/a1?callback=cb&format=kml", "", http.StatusBadRequest},
+		{"/scenes/a1?callback=cb&format=gpx", "", http.StatusBadRequest},
+		{"/scenes/a1?callback=cb", kmlType, http.StatusBadRequest},
+		{"/scenes/a1?callback=cb&format=geojson", "", http.StatusOK},
+		{"/scenes/a1?callback=cb", geoJSONType, http.StatusOK},
+		{"/scenes/a1?format=kml", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.code || strings.Contains(w.Body.String(), "cb(<?xml") {
+			t.Errorf("%v with Accept %q returned %v %v, want %v", c.path, c.accept, w.Code, w.Body.String(), c.code)
+		}
+		if w.Code == http.StatusBadRequest && w.Header().Get("Content-Type") == "application/javascript" {
+			t.Errorf("%v with Accept %q returned an error as JavaScript", c.path, c.accept)
+		}
+	}
+}
+
+func TestJSONPVary(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	handler := compressHandler(jsonpHandler(getHandler(scenesHandler)))
+	for _, path := range []string{"/scenes/a1?callback=cb", "/scenes/a1?callback=cb&format=geojson", "/scenes/a1"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		if vary := strings.Join(w.Header().Values("Vary"), ", "); vary != "Accept-Encoding, Accept" {
+			t.Errorf("%v returned Vary %q, want %q", path, vary, "Accept-Encoding, Accept")
+		}
+	}
+}
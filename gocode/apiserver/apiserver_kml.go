@@ -0,0 +1,130 @@
+// KML and GPX output for the endpoints that return scenes, so film locations can be opened in
+// Google Earth and in GPS and hiking apps. Every scene with a location becomes a KML placemark
+// or a GPX waypoint named after the location, the description holds the movie title, release
+// year and location name. The documents are built from the GeoJSON features of the results.
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+)
+
+const (
+	kmlType = "application/vnd.google-earth.kml+xml"
+	gpxType = "application/gpx+xml"
+
+	// the name of exported KML and GPX documents
+	exportName = "San Francisco film locations"
+)
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlPlacemark struct {
+	ID          string    `xml:"id,attr,omitempty"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"description"`
+	Data        []kmlData `xml:"ExtendedData>Data"`
+	Coordinates string    `xml:"Point>coordinates"` // longitude,latitude
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Name      string        `xml:"metadata>name"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+type gpxWaypoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Name string   `xml:"name"`
+	Desc string   `xml:"desc"`
+	Link *gpxLink `xml:"link,omitempty"`
+}
+
+type gpxLink struct {
+	Href string `xml:"href,attr"`
+	Text string `xml:"text,omitempty"`
+}
+
+// Returns the description of a scene feature, e.g. "Vertigo (1958), filmed at Fort Point".
+func featureDescription(p *FeatureProperties) string {
+	if p.MovieView == nil {
+		return p.Name
+	}
+	return p.Title + " (" + p.Year + "), filmed at " + p.Name
+}
+
+// Returns the KML document of the features. Features without a location are left out.
+func newKMLDocument(fc *FeatureCollection) *kmlDocument {
+	doc := &kmlDocument{Name: exportName, Placemarks: []kmlPlacemark{}}
+	for _, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		pm := kmlPlacemark{
+			ID:          f.ID,
+			Name:        f.Properties.Name,
+			Description: featureDescription(f.Properties),
+			Data:        []kmlData{{"IMDBID", f.Properties.IMDBID}},
+			Coordinates: strconv.FormatFloat(f.Geometry.Coordinates[0], 'f', -1, 64) + "," +
+				strconv.FormatFloat(f.Geometry.Coordinates[1], 'f', -1, 64),
+		}
+		if m := f.Properties.MovieView; m != nil {
+			pm.Data = append(pm.Data, kmlData{"Title", m.Title}, kmlData{"Year", m.Year})
+		}
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+	return doc
+}
+
+// Returns the GPX document of the features. Features without a location are left out.
+func newGPXDocument(fc *FeatureCollection) *gpxDocument {
+	doc := &gpxDocument{Version: "1.1", Creator: "San Francisco Movies API", Name: exportName}
+	for _, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		wpt := gpxWaypoint{
+			Lat:  f.Geometry.Coordinates[1],
+			Lon:  f.Geometry.Coordinates[0],
+			Name: f.Properties.Name,
+			Desc: featureDescription(f.Properties),
+		}
+		if m := f.Properties.MovieView; m != nil && f.Properties.IMDBID != "" {
+			wpt.Link = &gpxLink{"http://www.imdb.com/title/" + f.Properties.IMDBID + "/", m.Title}
+		}
+		doc.Waypoints = append(doc.Waypoints, wpt)
+	}
+	return doc
+}
+
+func writeKML(w http.ResponseWriter, fc *FeatureCollection) {
+	writeXML(w, kmlType, "sfmovies.kml", newKMLDocument(fc))
+}
+
+func writeGPX(w http.ResponseWriter, fc *FeatureCollection) {
+	writeXML(w, gpxType, "sfmovies.gpx", newGPXDocument(fc))
+}
+
+// Encodes v into XML and writes it as a download named filename.
+func writeXML(w http.ResponseWriter, contentType, filename string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	bts, err1 := xml.MarshalIndent(v, "", "  ")
+	_, err2 := w.Write(append([]byte(xml.Header), bts...))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "failed to marshal and write xml", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,77 @@
+// Tests for apiserver_kml.go
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+func TestKMLExport(t *testing.T) {
+	ad := resourcesTestData()
+	ad.Movies["tt0066999"] = (&sfmovies.MovieView{IMDBID: "tt0066999", Title: "Dirty Harry", Year: "1971"}).Movie()
+	defer serveTestData(ad)()
+
+	w := httptest.NewRecorder()
+	moviesHandler(w, httptest.NewRequest("GET", "/movies/tt0066999/scenes?format=kml", nil))
+	if ct := w.Header().Get("Content-Type"); ct != kmlType {
+		t.Errorf("Content-Type is %q, want %q", ct, kmlType)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "sfmovies.kml") {
+		t.Errorf("Content-Disposition is %q", cd)
+	}
+	if !strings.HasPrefix(w.Body.String(), xml.Header) || !strings.Contains(w.Body.String(), `<kml xmlns="http://www.opengis.net/kml/2.2">`) {
+		t.Errorf("Not a KML document: %v", w.Body.String())
+	}
+	var doc kmlDocument
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Placemarks) != 2 {
+		t.Fatalf("Got %v placemarks, want %v", len(doc.Placemarks), 2)
+	}
+	pm := doc.Placemarks[0]
+	if pm.ID != "a1" || pm.Name != "City Hall" || pm.Description != "Dirty Harry (1971), filmed at City Hall" || pm.Coordinates != "-122.4192646,37.7792597" {
+		t.Errorf("Got placemark %+v", pm)
+	}
+}
+
+func TestGPXExport(t *testing.T) {
+	defer serveTestData(resourcesTestData())()
+
+	r := httptest.NewRequest("GET", "/near?lat=37.7670&lng=-122.4563&limit=2", nil)
+	r.Header.Set("Accept", gpxType)
+	w := httptest.NewRecorder()
+	nearHandler(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != gpxType {
+		t.Errorf("Content-Type is %q, want %q", ct, gpxType)
+	}
+	if link := w.Header().Get("Link"); !strings.Contains(link, `rel="next"`) {
+		t.Errorf("Link header is %q, want a link to the next page", link)
+	}
+	var doc gpxDocument
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "1.1" || doc.XMLName.Space != "http://www.topografix.com/GPX/1/1" || len(doc.Waypoints) != 2 {
+		t.Fatalf("Got GPX document %+v", doc)
+	}
+	wpt := doc.Waypoints[0]
+	if wpt.Lat != 37.7670 || wpt.Lon != -122.4563 || wpt.Name != "Kezar Stadium" || wpt.Desc != "Dirty Harry (N/A), filmed at Kezar Stadium" {
+		t.Errorf("Got waypoint %+v", wpt)
+	}
+	if wpt.Link == nil || wpt.Link.Href != "http://www.imdb.com/title/tt0066999/" {
+		t.Errorf("Got waypoint link %+v", wpt.Link)
+	}
+
+	// a single scene is a document with one waypoint
+	w = httptest.NewRecorder()
+	scenesHandler(w, httptest.NewRequest("GET", "/scenes/b1?format=gpx", nil))
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "<wpt ") != 1 {
+		t.Errorf("/scenes/b1?format=gpx returned %v", w.Body.String())
+	}
+}
@@ -0,0 +1,263 @@
+// Prometheus metrics. /metrics returns the metrics of the API server in the Prometheus text
+// format: the number of requests per endpoint and status, their latency and response size,
+// the errors per status, the response cache, the data that is served and the Go runtime.
+// The requests are measured by metricsHandler, which wraps around every handler, so the
+// latency and size are those of the response as it is sent (compressed).
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The upper bounds of the latency (in seconds) and response size (in bytes) histograms.
+var (
+	durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+	sizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// The metrics of all requests handled by this server.
+var metrics = NewMetrics()
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] holds the observations <= bounds[i], the last one the rest
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// Writes the cumulative buckets, the sum and the count of the histogram with the labels.
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+type endpointMetrics struct {
+	requests map[int]uint64 // by status
+	duration *histogram
+	size     *histogram
+}
+
+// Request metrics per endpoint.
+type Metrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{endpoints: make(map[string]*endpointMetrics)}
+}
+
+// Records a request to the endpoint.
+func (m *Metrics) Observe(endpoint string, status int, d time.Duration, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	em, ok := m.endpoints[endpoint]
+	if !ok {
+		em = &endpointMetrics{
+			requests: make(map[int]uint64),
+			duration: newHistogram(durationBuckets),
+			size:     newHistogram(sizeBuckets),
+		}
+		m.endpoints[endpoint] = em
+	}
+	em.requests[status]++
+	em.duration.observe(d.Seconds())
+	em.size.observe(float64(size))
+}
+
+// Writes the request metrics, sorted by endpoint and status.
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoints := make([]string, 0, len(m.endpoints))
+	for e := range m.endpoints {
+		endpoints = append(endpoints, e)
+	}
+	sort.Strings(endpoints)
+	statuses := func(em *endpointMetrics) []int {
+		codes := make([]int, 0, len(em.requests))
+		for code := range em.requests {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		return codes
+	}
+
+	writeHeader(w, "sfmovies_http_requests_total", "counter", "Requests handled, by endpoint and status.")
+	for _, e := range endpoints {
+		em := m.endpoints[e]
+		for _, code := range statuses(em) {
+			fmt.Fprintf(w, "sfmovies_http_requests_total{endpoint=%q,status=\"%d\"} %d\n", e, code, em.requests[code])
+		}
+	}
+	writeHeader(w, "sfmovies_http_errors_total", "counter", "Requests that failed with a 4xx or 5xx status, by endpoint and status.")
+	for _, e := range endpoints {
+		em := m.endpoints[e]
+		for _, code := range statuses(em) {
+			if code >= 400 {
+				fmt.Fprintf(w, "sfmovies_http_errors_total{endpoint=%q,status=\"%d\"} %d\n", e, code, em.requests[code])
+			}
+		}
+	}
+	writeHeader(w, "sfmovies_http_request_duration_seconds", "histogram", "Time to handle a request, by endpoint.")
+	for _, e := range endpoints {
+		m.endpoints[e].duration.write(w, "sfmovies_http_request_duration_seconds", fmt.Sprintf("endpoint=%q", e))
+	}
+	writeHeader(w, "sfmovies_http_response_size_bytes", "histogram", "Size of the response body as sent, by endpoint.")
+	for _, e := range endpoints {
+		m.endpoints[e].size.write(w, "sfmovies_http_response_size_bytes", fmt.Sprintf("endpoint=%q", e))
+	}
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeMetric(w io.Writer, name, typ, help string, v float64) {
+	writeHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Returns the endpoint of a path, the route without IDs, so the number of label values stays small.
+func endpointLabel(path string) string {
+	switch path {
+	case "/", "/near", "/search", "/complete", "/within", "/people", "/status", "/metrics", "/export.csv", "/export.ndjson":
+		return path
+	}
+	for _, prefix := range []string{"/movies/", "/scenes/", "/locations/", "/people/"} {
+		if strings.HasPrefix(path, prefix) {
+			if prefix == "/movies/" && strings.HasSuffix(path, "/scenes") {
+				return "/movies/{id}/scenes"
+			}
+			return prefix + "{id}"
+		}
+	}
+	return "other"
+}
+
+// Measures the requests handled by fn.
+func metricsHandler(fn http.Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		mw := &metricsWriter{ResponseWriter: w, status: http.StatusOK}
+		fn.ServeHTTP(mw, r)
+		metrics.Observe(endpointLabel(r.URL.Path), mw.status, time.Since(start), mw.size)
+	}
+}
+
+// Records the status and the size of a response.
+type metricsWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	size        int
+}
+
+func (w *metricsWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metricsWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.size += n
+	return n, err
+}
+
+// Writes the metrics of the requests, the response cache, the data and the Go runtime.
+func metricsEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsType)
+	w.Header().Set("Cache-Control", "no-store")
+	metrics.write(w)
+
+	cs := responses.Stats()
+	writeMetric(w, "sfmovies_response_cache_hits_total", "counter", "Responses served from the response cache.", float64(cs.Hits))
+	writeMetric(w, "sfmovies_response_cache_misses_total", "counter", "Responses computed because they were not cached.", float64(cs.Misses))
+	writeMetric(w, "sfmovies_response_cache_coalesced_total", "counter", "Requests that waited for an identical request to compute the response.", float64(cs.Coalesced))
+	writeMetric(w, "sfmovies_response_cache_entries", "gauge", "Responses in the response cache.", float64(cs.Entries))
+	writeMetric(w, "sfmovies_response_cache_bytes", "gauge", "Size of the responses in the response cache.", float64(cs.Bytes))
+
+	st := state.Load()
+	writeMetric(w, "sfmovies_data_version_timestamp_seconds", "gauge", "Time the served data was created.", float64(st.Data.Time.UnixNano())/1e9)
+	writeMetric(w, "sfmovies_data_version_age_seconds", "gauge", "Age of the served data.", time.Since(st.Data.Time).Seconds())
+	writeMetric(w, "sfmovies_scenes", "gauge", "Scenes in the served data.", float64(len(st.Data.Scenes)))
+	writeMetric(w, "sfmovies_movies", "gauge", "Movies in the served data.", float64(len(st.Data.Movies)))
+	writeMetric(w, "sfmovies_trie_nodes", "gauge", "Nodes in the search trie.", float64(st.TrieNodes))
+	writeMetric(w, "sfmovies_draining", "gauge", "1 if the server is shutting down.", boolFloat(draining.Load()))
+	writeMetric(w, "process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.", float64(status.RunningSince.UnixNano())/1e9)
+
+	writeRuntimeMetrics(w)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Writes the Go runtime metrics under the names the official Prometheus client uses.
+func writeRuntimeMetrics(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	writeHeader(w, "go_info", "gauge", "Information about the Go environment.")
+	fmt.Fprintf(w, "go_info{version=%q} 1\n", runtime.Version())
+	writeMetric(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeMetric(w, "go_threads", "gauge", "Number of OS threads created.", float64(threads))
+	writeMetric(w, "go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.", float64(ms.Alloc))
+	writeMetric(w, "go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.", float64(ms.TotalAlloc))
+	writeMetric(w, "go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.", float64(ms.Sys))
+	writeMetric(w, "go_memstats_mallocs_total", "counter", "Total number of mallocs.", float64(ms.Mallocs))
+	writeMetric(w, "go_memstats_frees_total", "counter", "Total number of frees.", float64(ms.Frees))
+	writeMetric(w, "go_memstats_heap_alloc_bytes", "gauge", "Number of heap bytes allocated and still in use.", float64(ms.HeapAlloc))
+	writeMetric(w, "go_memstats_heap_sys_bytes", "gauge", "Number of heap bytes obtained from system.", float64(ms.HeapSys))
+	writeMetric(w, "go_memstats_heap_idle_bytes", "gauge", "Number of heap bytes waiting to be used.", float64(ms.HeapIdle))
+	writeMetric(w, "go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.", float64(ms.HeapInuse))
+	writeMetric(w, "go_memstats_heap_objects", "gauge", "Number of allocated objects.", float64(ms.HeapObjects))
+	writeMetric(w, "go_memstats_stack_inuse_bytes", "gauge", "Number of bytes in use by the stack allocator.", float64(ms.StackInuse))
+	writeMetric(w, "go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.", float64(ms.NextGC))
+	writeMetric(w, "go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.", float64(ms.LastGC)/1e9)
+	writeMetric(w, "go_memstats_gc_cpu_fraction", "gauge", "The fraction of this program's available CPU time used by the GC since the program started.", ms.GCCPUFraction)
+	writeMetric(w, "go_gc_cycles_total", "counter", "Number of completed GC cycles.", float64(ms.NumGC))
+	writeMetric(w, "go_gc_pause_seconds_total", "counter", "Total time the GC stopped the world.", float64(ms.PauseTotalNs)/1e9)
+}
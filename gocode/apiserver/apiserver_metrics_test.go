@@ -0,0 +1,99 @@
+// Tests for apiserver_metrics.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEndpointLabel(t *testing.T) {
+	cases := map[string]string{
+		"/":                        "/",
+		"/search":                  "/search",
+		"/movies/tt0028216":        "/movies/{id}",
+		"/movies/tt0028216/scenes": "/movies/{id}/scenes",
+		"/scenes/abc":              "/scenes/{id}",
+		"/people":                  "/people",
+		"/people/robin-williams":   "/people/{id}",
+		"/wp-login.php":            "other",
+	}
+	for path, want := range cases {
+		if got := endpointLabel(path); got != want {
+			t.Errorf("endpointLabel(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.observe(v)
+	}
+	var b strings.Builder
+	h.write(&b, "x", `a="b"`)
+	want := `x_bucket{a="b",le="1"} 2
+x_bucket{a="b",le="10"} 3
+x_bucket{a="b",le="+Inf"} 4
+x_sum{a="b"} 106.5
+x_count{a="b"} 4
+`
+	if b.String() != want {
+		t.Errorf("histogram written as\n%v\nwant\n%v", b.String(), want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	ad := resourcesTestData()
+	ad.Time = time.Now().Add(-time.Hour)
+	defer serveTestData(ad)()
+	prev := metrics
+	metrics = NewMetrics()
+	defer func() { metrics = prev }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rootHandler)
+	mux.HandleFunc("/movies/", moviesHandler)
+	mux.HandleFunc("/metrics", metricsEndpointHandler)
+	handler := metricsHandler(mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	get("/near?lat=37.78&lng=-122.42")
+	get("/near?lat=north&lng=-122.42")
+	get("/movies/tt0066999")
+	get("/movies/tt9999999")
+	get("/unknown")
+
+	w := get("/metrics")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != metricsType {
+		t.Fatalf("/metrics returned %v with headers %v", w.Code, w.Header())
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`sfmovies_http_requests_total{endpoint="/near",status="200"} 1`,
+		`sfmovies_http_requests_total{endpoint="/near",status="400"} 1`,
+		`sfmovies_http_requests_total{endpoint="/movies/{id}",status="404"} 1`,
+		`sfmovies_http_requests_total{endpoint="other",status="404"} 1`,
+		`sfmovies_http_errors_total{endpoint="/near",status="400"} 1`,
+		`sfmovies_http_request_duration_seconds_count{endpoint="/near"} 2`,
+		`sfmovies_http_response_size_bytes_count{endpoint="/movies/{id}"} 2`,
+		`# TYPE sfmovies_http_request_duration_seconds histogram`,
+		`sfmovies_movies 2`,
+		`sfmovies_trie_nodes `,
+		`sfmovies_data_version_age_seconds 3600`,
+		`go_goroutines `,
+		`go_memstats_heap_alloc_bytes `,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("/metrics doesn't contain %q", line)
+		}
+	}
+	if strings.Contains(body, `sfmovies_http_errors_total{endpoint="/near",status="200"}`) {
+		t.Errorf("/metrics counts successful requests as errors")
+	}
+}
@@ -0,0 +1,126 @@
+// Pagination of list endpoints. Every list endpoint accepts a limit and a cursor parameter and
+// returns a page of the results together with the total number of results. If there are more
+// results the page holds the cursor of the next page and a link to it.
+// A cursor is an opaque string that holds the offset of the next page and the version of the
+// data it was created for. The order of the results can change when newer data is swapped in,
+// so cursors of an older data version are rejected with 410 Gone instead of silently skipping
+// or repeating results. Clients should start again from the first page.
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The pagination fields of a list response. Cursor and Next are only set if there are more results.
+// Endpoints that would have to compute every result to know the total stop counting after
+// maxTotal results, TotalIsLowerBound is then set and Total is the number counted so far.
+type Page struct {
+	Total             int
+	TotalIsLowerBound bool   `json:",omitempty"`
+	Cursor            string `json:",omitempty"`
+	Next              string `json:",omitempty"`
+}
+
+// The number of results counted for the total of auto-complete and near queries, see Page.
+const maxTotal = 1000
+
+// The largest offset a cursor can hold, far more results than any list has. Larger offsets are
+// rejected, so that the end of a page can't overflow.
+const maxCursorOffset = 1 << 24
+
+var (
+	errBadCursor   = invalidParam("cursor", "invalid cursor")
+	errStaleCursor = &APIError{http.StatusGone, codeStaleCursor, "the cursor belongs to an older data version, start again without a cursor", "cursor"}
+)
+
+// The part of a list a request asks for.
+type pageRequest struct {
+	offset  int
+	limit   int
+	version time.Time
+}
+
+// Parses the limit and cursor parameters for data of the given version.
+// Returns errStaleCursor if the cursor was created for another data version.
+func parsePage(r *http.Request, version time.Time, def, max int) (pageRequest, error) {
+	limit, err := parseLimit(r, def, max)
+	if err != nil {
+		return pageRequest{}, err
+	}
+	p := pageRequest{limit: limit, version: version}
+	if v := r.FormValue("cursor"); v != "" {
+		var cursorVersion int64
+		cursorVersion, p.offset, err = decodeCursor(v)
+		if err != nil {
+			return pageRequest{}, err
+		}
+		if cursorVersion != version.UnixNano() {
+			return pageRequest{}, errStaleCursor
+		}
+	}
+	return p, nil
+}
+
+// The number of results the page must skip and hold, e.g. to ask the k-d tree for enough scenes.
+func (p pageRequest) end() int {
+	return p.offset + p.limit
+}
+
+// Returns the page of items.
+func paginate[T any](items []T, p pageRequest) []T {
+	lo := min(p.offset, len(items))
+	hi := min(p.end(), len(items))
+	return items[lo:hi]
+}
+
+// Returns the pagination fields for a list of total results.
+func (p pageRequest) page(r *http.Request, total int) Page {
+	pg := Page{Total: total}
+	if p.end() < total {
+		pg.Cursor = encodeCursor(p.version.UnixNano(), p.end())
+		u := *r.URL
+		q := u.Query()
+		q.Set("cursor", pg.Cursor)
+		u.RawQuery = q.Encode()
+		pg.Next = u.RequestURI()
+	}
+	return pg
+}
+
+// The number of results to compute for a page whose total is counted up to maxTotal: enough for
+// the page and one more, so it is known whether the total is a lower bound.
+func (p pageRequest) countLimit() int {
+	return max(p.end(), maxTotal) + 1
+}
+
+// Returns the pagination fields for a list of which n results were counted with countLimit.
+func (p pageRequest) boundedPage(r *http.Request, n int) Page {
+	pg := p.page(r, n)
+	pg.TotalIsLowerBound = n >= p.countLimit()
+	return pg
+}
+
+func encodeCursor(version int64, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(version, 36) + "." + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (version int64, offset int, err error) {
+	bts, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, errBadCursor
+	}
+	v, o, ok := strings.Cut(string(bts), ".")
+	if !ok {
+		return 0, 0, errBadCursor
+	}
+	version, err1 := strconv.ParseInt(v, 36, 64)
+	offset, err2 := strconv.Atoi(o)
+	if err1 != nil || err2 != nil || offset < 0 || offset > maxCursorOffset {
+		return 0, 0, errBadCursor
+	}
+	return version, offset, nil
+}
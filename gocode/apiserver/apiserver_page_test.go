@@ -0,0 +1,194 @@
+// Tests for apiserver_page.go
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	version := time.Date(2015, 3, 1, 4, 0, 0, 0, time.UTC).UnixNano()
+	for _, offset := range []int{0, 1, 20, 123456} {
+		v, o, err := decodeCursor(encodeCursor(version, offset))
+		if err != nil || v != version || o != offset {
+			t.Errorf("decodeCursor(encodeCursor(%v, %v)) == %v, %v, %v", version, offset, v, o, err)
+		}
+	}
+	for _, c := range []string{"x", "20", encodeCursor(version, 0)[1:], "MTIz", "IS4x", "MS4tMQ", encodeCursor(version, maxCursorOffset+1), encodeCursor(version, math.MaxInt)} {
+		if _, _, err := decodeCursor(c); err == nil {
+			t.Errorf("decodeCursor(%q) did not return an error", c)
+		}
+	}
+}
+
+// Requests path and decodes the JSON response into v. Returns the status code.
+func getJSON(t *testing.T, handler http.HandlerFunc, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", path, nil))
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%v returned %v: %v", path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+func TestNearPagination(t *testing.T) {
+	defer serveTestData(testAPIData(250))()
+
+	// Page through all scenes, following the next links
+	var scenes []NearScene
+	path := "/near?lat=37.77&lng=-122.42&limit=40"
+	for pages := 0; path != ""; pages++ {
+		var res NearResults
+		if code := getJSON(t, nearHandler, path, &res); code != http.StatusOK {
+			t.Fatalf("%v returned %v", path, code)
+		}
+		if res.Total != 250 {
+			t.Errorf("%v returned Total %v, want %v", path, res.Total, 250)
+		}
+		if len(res.Scenes) > 40 {
+			t.Errorf("%v returned %v scenes, want at most %v", path, len(res.Scenes), 40)
+		}
+		if (res.Cursor == "") != (res.Next == "") {
+			t.Errorf("%v returned cursor %q and next link %q", path, res.Cursor, res.Next)
+		}
+		scenes = append(scenes, res.Scenes...)
+		path = res.Next
+		if pages > 10 {
+			t.Fatalf("Too many pages")
+		}
+	}
+	if len(scenes) != 250 {
+		t.Fatalf("Got %v scenes, want %v", len(scenes), 250)
+	}
+	seen := make(map[string]bool)
+	for i, scene := range scenes {
+		if seen[scene.IMDBID] {
+			t.Errorf("Scene %v returned twice", scene.IMDBID)
+		}
+		seen[scene.IMDBID] = true
+		if i > 0 && scene.DistanceMeters < scenes[i-1].DistanceMeters {
+			t.Errorf("Scene %v is closer than the scene before it", i)
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	defer serveTestData(testAPIData(30))()
+
+	var first SearchResults
+	if code := getJSON(t, searchHandler, "/search?q=movie&limit=12", &first); code != http.StatusOK {
+		t.Fatalf("/search returned %v", code)
+	}
+	if first.Total != 30 || first.TotalMovies != 30 || len(first.Scenes) != 12 || len(first.Movies) != 12 || first.Cursor == "" {
+		t.Errorf("First page has %v of %v scenes, %v of %v movies and cursor %q", len(first.Scenes), first.Total, len(first.Movies), first.TotalMovies, first.Cursor)
+	}
+	var last SearchResults
+	if code := getJSON(t, searchHandler, "/search?q=movie&limit=12&cursor="+encodeCursor(state.Load().Data.Time.UnixNano(), 24), &last); code != http.StatusOK {
+		t.Fatalf("/search returned %v", code)
+	}
+	if len(last.Scenes) != 6 || last.Cursor != "" || last.Next != "" {
+		t.Errorf("Last page has %v scenes, cursor %q and next link %q", len(last.Scenes), last.Cursor, last.Next)
+	}
+}
+
+func TestCompletePagination(t *testing.T) {
+	defer serveTestData(testAPIData(30))()
+
+	var res CompleteResults
+	if code := getJSON(t, completeHandler, "/complete?term=movie&limit=5", &res); code != http.StatusOK {
+		t.Fatalf("/complete returned %v", code)
+	}
+	if res.Total != 30 || len(res.Completions) != 5 || res.Next == "" {
+		t.Errorf("/complete returned %v of %v completions and next link %q", len(res.Completions), res.Total, res.Next)
+	}
+	next, err := url.Parse(res.Next)
+	if err != nil || next.Query().Get("term") != "movie" || next.Query().Get("limit") != "5" {
+		t.Errorf("Next link %q does not repeat the query", res.Next)
+	}
+}
+
+// Totals of auto-complete and near queries are only counted up to maxTotal.
+func TestBoundedTotal(t *testing.T) {
+	defer serveTestData(testAPIData(maxTotal + 500))()
+	version := state.Load().Data.Time.UnixNano()
+
+	var complete CompleteResults
+	if code := getJSON(t, completeHandler, "/complete?term=movie", &complete); code != http.StatusOK {
+		t.Fatalf("/complete returned %v", code)
+	}
+	if complete.Total != maxTotal+1 || !complete.TotalIsLowerBound || complete.Next == "" {
+		t.Errorf("/complete returned Total %v, lower bound %v and next link %q", complete.Total, complete.TotalIsLowerBound, complete.Next)
+	}
+
+	cases := []struct {
+		path  string
+		total int
+		bound bool
+	}{
+		{"/near?lat=37.77&lng=-122.42", maxTotal + 1, true},
+		{"/near?lat=37.77&lng=-122.42&imdb_id=tt0000001", 1, false},
+		{"/near?lat=37.77&lng=-122.42&limit=100&cursor=" + encodeCursor(version, maxTotal), maxTotal + 101, true},
+		{"/near?lat=37.77&lng=-122.42&limit=100&cursor=" + encodeCursor(version, maxTotal+400), maxTotal + 500, false},
+	}
+	for _, c := range cases {
+		var res NearResults
+		if code := getJSON(t, nearHandler, c.path, &res); code != http.StatusOK {
+			t.Fatalf("%v returned %v", c.path, code)
+		}
+		if res.Total != c.total || res.TotalIsLowerBound != c.bound || (res.Next == "") == c.bound {
+			t.Errorf("%v returned Total %v, lower bound %v and next link %q, want %v and %v", c.path, res.Total, res.TotalIsLowerBound, res.Next, c.total, c.bound)
+		}
+	}
+}
+
+func TestStaleCursor(t *testing.T) {
+	ad := testAPIData(50)
+	ad.Time = time.Date(2015, 3, 1, 4, 0, 0, 0, time.UTC)
+	defer serveTestData(ad)()
+
+	var res WithinResults
+	path := "/within?minLat=37.5&minLng=-122.7&maxLat=38&maxLng=-122&limit=10"
+	if code := getJSON(t, withinHandler, path, &res); code != http.StatusOK || res.Next == "" {
+		t.Fatalf("%v returned %v with next link %q", path, code, res.Next)
+	}
+
+	newer := testAPIData(50)
+	newer.Time = ad.Time.Add(24 * time.Hour)
+	swapAPIData(newer)
+
+	if code := getJSON(t, withinHandler, res.Next, &res); code != http.StatusGone {
+		t.Errorf("%v returned %v after a data swap, want %v", res.Next, code, http.StatusGone)
+	}
+	if code := getJSON(t, withinHandler, path+"&cursor=x", &res); code != http.StatusBadRequest {
+		t.Errorf("Malformed cursor returned %v, want %v", code, http.StatusBadRequest)
+	}
+}
+
+// A crafted cursor for the current data version must not make the end of the page overflow.
+func TestCraftedCursor(t *testing.T) {
+	defer serveTestData(testAPIData(50))()
+
+	version := state.Load().Data.Time.UnixNano()
+	for _, offset := range []int{maxCursorOffset + 1, math.MaxInt - 5} {
+		cursor := encodeCursor(version, offset)
+		for _, path := range []string{"/near?lat=37.77&lng=-122.42", "/search?q=movie", "/complete?term=movie", "/within?minLat=37.5&minLng=-122.7&maxLat=38&maxLng=-122"} {
+			w := httptest.NewRecorder()
+			rootHandler(w, httptest.NewRequest("GET", path+"&cursor="+cursor, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v with cursor offset %v returned %v, want %v", path, offset, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+	var res NearResults
+	path := "/near?lat=37.77&lng=-122.42&cursor=" + encodeCursor(version, maxCursorOffset)
+	if code := getJSON(t, nearHandler, path, &res); code != http.StatusOK || len(res.Scenes) != 0 || res.Next != "" {
+		t.Errorf("%v returned %v with %v scenes and next link %q", path, code, len(res.Scenes), res.Next)
+	}
+}
@@ -0,0 +1,186 @@
+// The people index. Actors, directors and writers are collected from the movies when the data
+// is loaded, so a person's movies, roles and filming locations can be looked up by their slug.
+// The slug is the cleaned up name with dashes between the words, e.g. "robin-williams".
+// Names that only differ in accents or case, like "Zoë Saldaña" and "Zoe Saldana", share a slug
+// and are treated as the same person.
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// A person with everything they worked on. Returned by /people/{slug}.
+type PersonInfo struct {
+	Name    string
+	Slug    string
+	Roles   []string // TypeActor, TypeDirector and TypeWriter, in that order
+	Credits []Credit
+	Movies  []*sfmovies.Movie
+	Scenes  []*sfmovies.Scene
+
+	words []string // the cleaned up name
+}
+
+// A role a person held in a movie.
+type Credit struct {
+	IMDBID string
+	Role   string
+	Note   string `json:",omitempty"`
+}
+
+// A person as returned by people queries. Movies is the number of movies they worked on.
+type PersonSummary struct {
+	Name   string
+	Slug   string
+	Roles  []string
+	Movies int
+}
+
+type PeopleIndex struct {
+	bySlug map[string]*PersonInfo
+	sorted []*PersonInfo // most movies first
+}
+
+// Returns the slug of a name, or "" if the name has no letters or digits.
+func Slug(name string) string {
+	return strings.Join(strings.Fields(CleanString(name)), "-")
+}
+
+// Collects the people of all movies.
+func NewPeopleIndex(ad *sfmovies.APIData) *PeopleIndex {
+	idx := &PeopleIndex{bySlug: make(map[string]*PersonInfo)}
+
+	// the movies are visited in order so the same spelling of a shared name is always used
+	imdbids := make([]string, 0, len(ad.Movies))
+	for imdbid := range ad.Movies {
+		imdbids = append(imdbids, imdbid)
+	}
+	sort.Strings(imdbids)
+
+	scenes := make(map[string][]*sfmovies.Scene)
+	for _, scene := range ad.Scenes {
+		if scene.Location != nil {
+			scenes[scene.IMDBID] = append(scenes[scene.IMDBID], scene)
+		}
+	}
+
+	for _, imdbid := range imdbids {
+		movie := ad.Movies[imdbid]
+		credits := []struct {
+			people []sfmovies.Person
+			role   string
+		}{
+			{movie.Actors, TypeActor},
+			{movie.Directors, TypeDirector},
+			{movie.Writers, TypeWriter},
+		}
+		for _, c := range credits {
+			for _, p := range c.people {
+				idx.addCredit(p, c.role, movie, scenes[imdbid])
+			}
+		}
+	}
+
+	for _, p := range idx.bySlug {
+		sortScenes(p.Scenes)
+		idx.sorted = append(idx.sorted, p)
+	}
+	sort.Slice(idx.sorted, func(i, j int) bool {
+		a, b := idx.sorted[i], idx.sorted[j]
+		if len(a.Movies) != len(b.Movies) {
+			return len(a.Movies) > len(b.Movies)
+		}
+		return a.Slug < b.Slug
+	})
+	return idx
+}
+
+// Records that p held role in movie.
+func (idx *PeopleIndex) addCredit(p sfmovies.Person, role string, movie *sfmovies.Movie, scenes []*sfmovies.Scene) {
+	slug := Slug(p.Name)
+	if slug == "" {
+		return
+	}
+	info, ok := idx.bySlug[slug]
+	if !ok {
+		info = &PersonInfo{
+			Name:    p.Name,
+			Slug:    slug,
+			Roles:   []string{},
+			Credits: []Credit{},
+			Movies:  []*sfmovies.Movie{},
+			Scenes:  []*sfmovies.Scene{},
+			words:   strings.Split(slug, "-"),
+		}
+		idx.bySlug[slug] = info
+	}
+	for _, c := range info.Credits {
+		if c.IMDBID == movie.IMDBID && c.Role == role {
+			return
+		}
+	}
+	info.Credits = append(info.Credits, Credit{movie.IMDBID, role, p.Note})
+	if !contains(info.Roles, role) {
+		info.Roles = append(info.Roles, role)
+		sort.Slice(info.Roles, func(i, j int) bool { return roleOrder[info.Roles[i]] < roleOrder[info.Roles[j]] })
+	}
+	if n := len(info.Movies); n == 0 || info.Movies[n-1] != movie {
+		info.Movies = append(info.Movies, movie)
+		info.Scenes = append(info.Scenes, scenes...)
+	}
+}
+
+var roleOrder = map[string]int{TypeActor: 0, TypeDirector: 1, TypeWriter: 2}
+
+// Sorts scenes by movie and location name so a person's scenes are always listed in the same order.
+func sortScenes(scs []*sfmovies.Scene) {
+	sort.Slice(scs, func(i, j int) bool {
+		a, b := scs[i], scs[j]
+		if a.IMDBID != b.IMDBID {
+			return a.IMDBID < b.IMDBID
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Lat != b.Lat {
+			return a.Lat < b.Lat
+		}
+		return a.Lng < b.Lng
+	})
+}
+
+// Returns the person with the slug, or nil if there is none.
+func (idx *PeopleIndex) Get(slug string) *PersonInfo {
+	return idx.bySlug[slug]
+}
+
+// Returns the people whose name contains words starting with every word of q,
+// the people with the most movies first. An empty query returns everyone.
+func (idx *PeopleIndex) Find(q string) []PersonSummary {
+	words := strings.Fields(CleanString(q))
+	result := make([]PersonSummary, 0)
+	for _, p := range idx.sorted {
+		if !p.matches(words) {
+			continue
+		}
+		result = append(result, PersonSummary{p.Name, p.Slug, p.Roles, len(p.Movies)})
+	}
+	return result
+}
+
+// Checks if every query word is the start of a word of the name.
+func (p *PersonInfo) matches(words []string) bool {
+	for _, w := range words {
+		found := false
+		for _, nw := range p.words {
+			found = found || strings.HasPrefix(nw, w)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+// Tests for apiserver_people.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Creates APIData with people who worked on several movies in different roles.
+func peopleTestData() *sfmovies.APIData {
+	ad := sfmovies.NewAPIData()
+	views := []*sfmovies.MovieView{
+		{IMDBID: "tt0066999", Title: "Dirty Harry", Director: "Don Siegel", Writer: "Harry Julian Fink (screenplay), Dean Riesner (screenplay)", Actors: "Clint Eastwood, Harry Guardino"},
+		{IMDBID: "tt0070355", Title: "Magnum Force", Director: "Ted Post", Writer: "Harry Julian Fink (characters), John Milius (screenplay)", Actors: "Clint Eastwood, Hal Holbrook"},
+		{IMDBID: "tt0079116", Title: "Escape from Alcatraz", Director: "Don Siegel", Actors: "Clint Eastwood, Patrick McGoohan"},
+		{IMDBID: "tt0087932", Title: "Sudden Impact", Director: "Clint Eastwood", Actors: "Clint Eastwood, Sondra Locke"},
+		{IMDBID: "tt1000000", Title: "Café Society", Actors: "Zoë Saldaña"},
+		{IMDBID: "tt1000001", Title: "Out of Towners", Actors: "Zoe Saldana"},
+	}
+	for _, view := range views {
+		movie := view.Movie()
+		ad.Movies[movie.IMDBID] = movie
+	}
+	locations := []struct {
+		imdbid, name string
+	}{
+		{"tt0066999", "Golden Gate Park"},
+		{"tt0066999", "City Hall"},
+		{"tt0070355", "Lombard Street"},
+		{"tt0079116", "Alcatraz Island"},
+		{"tt1000000", "Coit Tower"},
+	}
+	for i, l := range locations {
+		ad.Scenes[l.name] = &sfmovies.Scene{IMDBID: l.imdbid, Location: &sfmovies.Location{Name: l.name, Lat: 37.78 + float64(i)/100, Lng: -122.42}}
+	}
+	return ad
+}
+
+func TestSlug(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"Robin Williams", "robin-williams"},
+		{"Zoë  Saldaña", "zoe-saldana"},
+		{"Samuel A. Taylor", "samuel-a-taylor"},
+		{"Sean O'Brien", "sean-obrien"},
+		{"...", ""},
+	}
+	for _, c := range cases {
+		if got := Slug(c.in); got != c.out {
+			t.Errorf("Slug(%q) == %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestPeopleIndexGet(t *testing.T) {
+	idx := NewPeopleIndex(peopleTestData())
+
+	clint := idx.Get("clint-eastwood")
+	if clint == nil {
+		t.Fatal("clint-eastwood not found")
+	}
+	if want := []string{TypeActor, TypeDirector}; !reflect.DeepEqual(clint.Roles, want) {
+		t.Errorf("Roles == %v, want %v", clint.Roles, want)
+	}
+	if len(clint.Movies) != 4 || len(clint.Credits) != 5 {
+		t.Errorf("Got %v movies and %v credits, want 4 and 5", len(clint.Movies), len(clint.Credits))
+	}
+	var names []string
+	for _, scene := range clint.Scenes {
+		names = append(names, scene.Name)
+	}
+	if want := []string{"City Hall", "Golden Gate Park", "Lombard Street", "Alcatraz Island"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Scenes == %v, want %v", names, want)
+	}
+
+	fink := idx.Get("harry-julian-fink")
+	if fink == nil {
+		t.Fatal("harry-julian-fink not found")
+	}
+	want := []Credit{{"tt0066999", TypeWriter, "screenplay"}, {"tt0070355", TypeWriter, "characters"}}
+	if !reflect.DeepEqual(fink.Credits, want) {
+		t.Errorf("Credits == %v, want %v", fink.Credits, want)
+	}
+
+	// names that only differ in accents are the same person, spelled as in the first movie
+	zoe := idx.Get("zoe-saldana")
+	if zoe == nil || zoe.Name != "Zoë Saldaña" || len(zoe.Movies) != 2 {
+		t.Errorf("Get(%q) == %+v", "zoe-saldana", zoe)
+	}
+
+	if p := idx.Get("nobody"); p != nil {
+		t.Errorf("Get(%q) == %+v, want nil", "nobody", p)
+	}
+}
+
+func TestPeopleIndexFind(t *testing.T) {
+	idx := NewPeopleIndex(peopleTestData())
+	cases := []struct {
+		q     string
+		slugs []string
+	}{
+		{"harry", []string{"harry-julian-fink", "harry-guardino"}},
+		{"har", []string{"harry-julian-fink", "harry-guardino"}},
+		{"fink harry", []string{"harry-julian-fink"}},
+		{"arry", []string{}},
+		{"ZOË", []string{"zoe-saldana"}},
+	}
+	for _, c := range cases {
+		slugs := []string{}
+		for _, p := range idx.Find(c.q) {
+			slugs = append(slugs, p.Slug)
+		}
+		if !reflect.DeepEqual(slugs, c.slugs) {
+			t.Errorf("Find(%q) == %v, want %v", c.q, slugs, c.slugs)
+		}
+	}
+	if all := idx.Find(""); len(all) != 11 || all[0].Slug != "clint-eastwood" || all[1].Slug != "don-siegel" {
+		t.Errorf("Find(%q) == %v, want everyone with the most movies first", "", all)
+	}
+}
+
+func TestPeopleHandler(t *testing.T) {
+	defer serveTestData(peopleTestData())()
+
+	w := httptest.NewRecorder()
+	peopleHandler(w, httptest.NewRequest("GET", "/people?q=siegel", nil))
+	var people PeopleResults
+	if err := json.Unmarshal(w.Body.Bytes(), &people); err != nil {
+		t.Fatal(err)
+	}
+	if len(people.People) != 1 || people.People[0].Slug != "don-siegel" || people.People[0].Movies != 2 || people.Total != 1 {
+		t.Errorf("/people?q=siegel returned %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	peopleHandler(w, httptest.NewRequest("GET", "/people/don-siegel", nil))
+	var person PersonInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &person); err != nil {
+		t.Fatal(err)
+	}
+	if person.Name != "Don Siegel" || len(person.Movies) != 2 || person.Movies[0].Title != "Dirty Harry" {
+		t.Errorf("/people/don-siegel returned %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	peopleHandler(w, httptest.NewRequest("GET", "/people?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("/people?limit=0 returned %v, want %v", w.Code, http.StatusBadRequest)
+	}
+}
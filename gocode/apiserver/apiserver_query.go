@@ -0,0 +1,112 @@
+// Parsing of search queries. A query is a list of terms separated by whitespace. A term is a
+// word or a phrase between double quotes and can be scoped to a field by prefixing it with the
+// name of the field and a colon, e.g.
+//
+//	actor:"robin williams" director:hitchcock year:1958 location:"coit tower" bridge
+//
+// Scoped terms only match words in that field, unscoped terms match words in every field.
+// The words of a phrase have to occur next to each other in a single field.
+// The parser never fails: unknown field names are searched as normal words and a missing
+// closing quote ends the phrase at the end of the query.
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// A term of a search query.
+type QueryTerm struct {
+	Field  Field    // zero if the term is not scoped to a field
+	Words  []string // cleaned up words
+	Phrase bool
+}
+
+// The field names that can be used in queries.
+var fieldNames = map[string]Field{
+	"title":    FieldTitle,
+	"year":     FieldYear,
+	"writer":   FieldWriter,
+	"director": FieldDirector,
+	"actor":    FieldActor,
+	"location": FieldLocation,
+}
+
+// Splits the query into terms.
+func ParseQuery(q string) []QueryTerm {
+	terms := make([]QueryTerm, 0)
+	rs := []rune(q)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+
+		// field prefix
+		var field Field
+		j := i
+		for j < len(rs) && unicode.IsLetter(rs[j]) {
+			j++
+		}
+		if j < len(rs) && rs[j] == ':' {
+			if f, ok := fieldNames[strings.ToLower(string(rs[i:j]))]; ok {
+				field = f
+				i = j + 1
+			}
+		}
+
+		// phrase
+		if i < len(rs) && rs[i] == '"' {
+			j = i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			words := strings.Fields(CleanString(string(rs[i+1 : j])))
+			if len(words) > 0 {
+				terms = append(terms, QueryTerm{field, words, len(words) > 1})
+			}
+			i = j + 1
+			continue
+		}
+
+		// word, a colon that is not part of a known field prefix separates words
+		j = i
+		for j < len(rs) && !unicode.IsSpace(rs[j]) {
+			j++
+		}
+		for _, word := range strings.Fields(CleanString(strings.Replace(string(rs[i:j]), ":", " ", -1))) {
+			terms = append(terms, QueryTerm{field, []string{word}, false})
+		}
+		i = j
+	}
+	return terms
+}
+
+// Returns the fields in which the words occur next to each other. Only the fields in scope are
+// checked. Lists of names are checked name by name so that a phrase doesn't span two people.
+func phraseFields(ad *sfmovies.APIData, scene *sfmovies.Scene, words []string, scope Field) Field {
+	var r Field
+	texts := map[Field][]string{FieldLocation: {scene.Name}}
+	if movie, ok := ad.Movies[scene.IMDBID]; ok {
+		texts[FieldTitle] = []string{movie.Title}
+		texts[FieldYear] = []string{movie.Year.String()}
+		texts[FieldWriter] = sfmovies.Names(movie.Writers)
+		texts[FieldDirector] = sfmovies.Names(movie.Directors)
+		texts[FieldActor] = sfmovies.Names(movie.Actors)
+	}
+	phrase := " " + strings.Join(words, " ") + " "
+	for field, strs := range texts {
+		if field&scope == 0 {
+			continue
+		}
+		for _, str := range strs {
+			if strings.Contains(" "+strings.Join(strings.Fields(CleanString(str)), " ")+" ", phrase) {
+				r |= field
+				break
+			}
+		}
+	}
+	return r
+}
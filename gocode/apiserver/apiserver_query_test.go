@@ -0,0 +1,97 @@
+// Tests for apiserver_query.go
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		in  string
+		out []QueryTerm
+	}{
+		{"", []QueryTerm{}},
+		{"   ", []QueryTerm{}},
+		{"golden gate", []QueryTerm{{0, []string{"golden"}, false}, {0, []string{"gate"}, false}}},
+		{`"golden gate"`, []QueryTerm{{0, []string{"golden", "gate"}, true}}},
+		{`actor:"Robin Williams" director:hitchcock year:1958 location:"coit tower"`, []QueryTerm{
+			{FieldActor, []string{"robin", "williams"}, true},
+			{FieldDirector, []string{"hitchcock"}, false},
+			{FieldYear, []string{"1958"}, false},
+			{FieldLocation, []string{"coit", "tower"}, true},
+		}},
+		{"Title:Vertigo bridge", []QueryTerm{{FieldTitle, []string{"vertigo"}, false}, {0, []string{"bridge"}, false}}},
+		{`actor:"sean`, []QueryTerm{{FieldActor, []string{"sean"}, false}}},
+		{"foo:bar", []QueryTerm{{0, []string{"foo"}, false}, {0, []string{"bar"}, false}}},
+		{`writer:"" actor: x`, []QueryTerm{{0, []string{"x"}, false}}},
+		{"director:o'brien", []QueryTerm{{FieldDirector, []string{"obrien"}, false}}},
+	}
+	for _, c := range cases {
+		got := ParseQuery(c.in)
+		if !reflect.DeepEqual(got, c.out) {
+			t.Errorf("ParseQuery(%q) == %v, want %v", c.in, got, c.out)
+		}
+	}
+}
+
+func TestScopedSearch(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = (&sfmovies.MovieView{IMDBID: "tt1", Title: "Mrs. Doubtfire", Year: "1993", Director: "Chris Columbus", Actors: "Robin Williams, Sally Field"}).Movie()
+	ad.Movies["tt2"] = (&sfmovies.MovieView{IMDBID: "tt2", Title: "Vertigo", Year: "1958", Director: "Alfred Hitchcock", Writer: "Bill Walsh", Actors: "James Stewart, Robin Wright, Sally Williams"}).Movie()
+	ad.Movies["tt3"] = (&sfmovies.MovieView{IMDBID: "tt3", Title: "Bill", Year: "2015", Director: "Bill Guttentag"}).Movie()
+	doubtfire := &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "2640 Steiner Street"}}
+	vertigo := &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "Coit Tower"}}
+	bill := &sfmovies.Scene{IMDBID: "tt3", Location: &sfmovies.Location{Name: "Tower Street Coit"}}
+	ad.Scenes["1"], ad.Scenes["2"], ad.Scenes["3"] = doubtfire, vertigo, bill
+	n := CreateTrie(ad)
+
+	cases := []struct {
+		q      string
+		scenes []*sfmovies.Scene
+	}{
+		{"bill", []*sfmovies.Scene{vertigo, bill}},
+		{"director:bill", []*sfmovies.Scene{bill}},
+		{"writer:bill", []*sfmovies.Scene{vertigo}},
+		{"actor:bill", nil},
+		{"title:bill", []*sfmovies.Scene{bill}},
+		{"robin williams", []*sfmovies.Scene{doubtfire, vertigo}},
+		{`"robin williams"`, []*sfmovies.Scene{doubtfire}},
+		{`actor:"robin williams"`, []*sfmovies.Scene{doubtfire}},
+		{`actor:"robin williams" year:1958`, nil},
+		{"director:hitchcock year:1958", []*sfmovies.Scene{vertigo}},
+		{`location:"coit tower"`, []*sfmovies.Scene{vertigo}},
+		{"location:coit location:tower", []*sfmovies.Scene{vertigo, bill}},
+		{`title:"coit tower"`, nil},
+		{`"williams sally"`, nil},
+	}
+	for _, c := range cases {
+		got := n.Get(ad, c.q, true, 0)
+		if got == nil {
+			if c.scenes != nil {
+				t.Errorf("Get(%q) == nil, want %v scenes", c.q, len(c.scenes))
+			}
+			continue
+		}
+		M := make(map[*sfmovies.Scene]bool)
+		for _, scene := range got.Scenes {
+			M[scene.Scene] = true
+		}
+		if len(M) != len(c.scenes) {
+			t.Errorf("Get(%q) returned %v scenes, want %v", c.q, len(M), len(c.scenes))
+		}
+		for _, scene := range c.scenes {
+			if !M[scene] {
+				t.Errorf("Get(%q) does not contain %v", c.q, scene.Name)
+			}
+		}
+	}
+
+	// unscoped terms still match any term with op=or
+	got := n.Get(ad, `actor:"robin williams" director:hitchcock`, false, 0)
+	if got == nil || len(got.Scenes) != 2 {
+		t.Errorf("Get with op=or returned %v, want 2 scenes", got)
+	}
+}
@@ -0,0 +1,149 @@
+// Ranking of search results. Every scene gets a score based on the fields in which the query
+// words were found. Title matches weigh more than actor and director matches, which weigh more
+// than location matches. The sum of the weights is multiplied by the fraction of query words that
+// matched, so scenes that match more words come first. Movies whose title is exactly the query
+// are boosted above everything else. A movie gets the score of its best scene.
+// Scenes that were only found by correcting typos in the query always come after exact matches,
+// the fewer edits were needed the better.
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// The weight of a word that is found in a field.
+var fieldWeights = map[Field]float64{
+	FieldTitle:    4,
+	FieldActor:    3,
+	FieldDirector: 3,
+	FieldWriter:   2,
+	FieldLocation: 2,
+	FieldYear:     1,
+	FieldOther:    1,
+}
+
+// Added to the score of scenes whose movie title is exactly the query.
+const exactTitleBoost = 100
+
+// The results of a search query, best results first. Movies and scenes are paged together,
+// Total is the number of scenes and TotalMovies the number of movies.
+type SearchResults struct {
+	Movies      []*ScoredMovie
+	Scenes      []*ScoredScene
+	TotalMovies int
+	Page
+}
+
+type ScoredMovie struct {
+	*sfmovies.Movie
+	Score        float64
+	EditDistance int `json:",omitempty"`
+}
+
+// The JSON of a scored movie is the view of the movie with the score added. Without it the
+// JSON methods of the embedded movie would be used and the score would be lost.
+type scoredMovieJSON struct {
+	*sfmovies.MovieView
+	Score        float64
+	EditDistance int `json:",omitempty"`
+}
+
+func (m *ScoredMovie) MarshalJSON() ([]byte, error) {
+	return json.Marshal(scoredMovieJSON{m.Movie.View(), m.Score, m.EditDistance})
+}
+
+func (m *ScoredMovie) UnmarshalJSON(data []byte) error {
+	v := scoredMovieJSON{MovieView: new(sfmovies.MovieView)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = ScoredMovie{v.MovieView.Movie(), v.Score, v.EditDistance}
+	return nil
+}
+
+type ScoredScene struct {
+	*sfmovies.Scene
+	Score        float64
+	EditDistance int `json:",omitempty"`
+}
+
+// Reports whether a result with score s1 and distance d1 ranks above one with s2 and d2.
+func better(s1 float64, d1 int, s2 float64, d2 int) bool {
+	if d1 != d2 {
+		return d1 < d2
+	}
+	return s1 > s2
+}
+
+// Returns the weight of the heaviest field in f.
+func fieldWeight(f Field) float64 {
+	w := 0.0
+	for field, fw := range fieldWeights {
+		if f&field != 0 && fw > w {
+			w = fw
+		}
+	}
+	return w
+}
+
+// Scores the scenes and composes the list of movies. found holds for every scene how each
+// of the words matched.
+func rankResults(ad *sfmovies.APIData, words []string, found map[*sfmovies.Scene][]wordMatch) *SearchResults {
+	query := strings.Join(words, " ")
+
+	r := new(SearchResults)
+	r.Scenes = make([]*ScoredScene, 0, len(found))
+	r.Movies = make([]*ScoredMovie, 0)
+	movies := make(map[string]*ScoredMovie)
+	for scene, wms := range found {
+		score, matched, dist := 0.0, 0, 0
+		for _, wm := range wms {
+			if wm.fields != 0 {
+				score += fieldWeight(wm.fields)
+				matched++
+				dist += wm.dist
+			}
+		}
+		// the fraction of the query words that matched
+		score *= float64(matched) / float64(len(wms))
+
+		movie, ok := ad.Movies[scene.IMDBID]
+		if ok && dist == 0 && strings.Join(strings.Fields(CleanString(movie.Title)), " ") == query {
+			score += exactTitleBoost
+		}
+		r.Scenes = append(r.Scenes, &ScoredScene{scene, score, dist})
+
+		if !ok {
+			continue
+		}
+		if m, ok := movies[movie.IMDBID]; !ok {
+			movies[movie.IMDBID] = &ScoredMovie{movie, score, dist}
+			r.Movies = append(r.Movies, movies[movie.IMDBID])
+		} else if better(score, dist, m.Score, m.EditDistance) {
+			m.Score, m.EditDistance = score, dist
+		}
+	}
+
+	sort.Slice(r.Scenes, func(i, j int) bool {
+		a, b := r.Scenes[i], r.Scenes[j]
+		if a.Score != b.Score || a.EditDistance != b.EditDistance {
+			return better(a.Score, a.EditDistance, b.Score, b.EditDistance)
+		}
+		if a.IMDBID != b.IMDBID {
+			return a.IMDBID < b.IMDBID
+		}
+		return a.Location != nil && b.Location != nil && a.Name < b.Name
+	})
+	sort.Slice(r.Movies, func(i, j int) bool {
+		a, b := r.Movies[i], r.Movies[j]
+		if a.Score != b.Score || a.EditDistance != b.EditDistance {
+			return better(a.Score, a.EditDistance, b.Score, b.EditDistance)
+		}
+		return a.Title < b.Title
+	})
+	return r
+}
@@ -0,0 +1,90 @@
+// Tests for apiserver_rank.go
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+func TestFieldWeight(t *testing.T) {
+	cases := []struct {
+		in  Field
+		out float64
+	}{
+		{0, 0},
+		{FieldYear, 1},
+		{FieldLocation, 2},
+		{FieldActor, 3},
+		{FieldTitle, 4},
+		{FieldLocation | FieldActor, 3},
+		{FieldYear | FieldTitle | FieldWriter, 4},
+	}
+	for _, c := range cases {
+		got := fieldWeight(c.in)
+		if got != c.out {
+			t.Errorf("fieldWeight(%v) == %v, want %v", c.in, got, c.out)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = (&sfmovies.MovieView{IMDBID: "tt1", Title: "Vertigo", Actors: "James Stewart"}).Movie()
+	ad.Movies["tt2"] = (&sfmovies.MovieView{IMDBID: "tt2", Title: "Vertigo Returns", Actors: "Kim Novak"}).Movie()
+	ad.Movies["tt3"] = (&sfmovies.MovieView{IMDBID: "tt3", Title: "Dirty Harry", Actors: "Clint Eastwood, Vertigo Smith"}).Movie()
+	ad.Movies["tt4"] = (&sfmovies.MovieView{IMDBID: "tt4", Title: "Bullitt", Actors: "Steve McQueen"}).Movie()
+	location := &sfmovies.Scene{IMDBID: "tt4", Location: &sfmovies.Location{Name: "Vertigo Hotel"}}
+	actor := &sfmovies.Scene{IMDBID: "tt3", Location: &sfmovies.Location{Name: "City Hall"}}
+	title := &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "Fort Point"}}
+	exact := &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "Mission Dolores"}}
+	ad.Scenes["1"], ad.Scenes["2"], ad.Scenes["3"], ad.Scenes["4"] = location, actor, title, exact
+	n := CreateTrie(ad)
+
+	got := n.Get(ad, "vertigo", true, 0)
+	want := []*sfmovies.Scene{exact, title, actor, location}
+	if len(got.Scenes) != len(want) {
+		t.Fatalf("Got %v scenes, want %v", len(got.Scenes), len(want))
+	}
+	for i := range want {
+		if got.Scenes[i].Scene != want[i] {
+			t.Errorf("Scene %v is %v, want %v", i, got.Scenes[i].Name, want[i].Name)
+		}
+		if i > 0 && got.Scenes[i].Score >= got.Scenes[i-1].Score {
+			t.Errorf("Scene %v has score %v, not below %v", i, got.Scenes[i].Score, got.Scenes[i-1].Score)
+		}
+	}
+	if got.Movies[0].IMDBID != "tt1" || got.Movies[0].Score != got.Scenes[0].Score {
+		t.Errorf("Best movie is %v with score %v, want %v with score %v", got.Movies[0].Title, got.Movies[0].Score, "Vertigo", got.Scenes[0].Score)
+	}
+
+	// Scenes that match more words come first
+	got = n.Get(ad, "vertigo hotel", false, 0)
+	if got.Scenes[0].Scene != location {
+		t.Errorf("First scene is %v, want %v", got.Scenes[0].Name, location.Name)
+	}
+}
+
+func TestScoredMovieJSON(t *testing.T) {
+	movie := (&sfmovies.MovieView{IMDBID: "tt1", Title: "Vertigo", Runtime: "128 min", Actors: "James Stewart"}).Movie()
+	bts, err := json.Marshal(&ScoredMovie{movie, 4.5, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(bts, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["Runtime"] != "128 min" || fields["Actors"] != "James Stewart" || fields["Score"] != 4.5 || fields["EditDistance"] != 1.0 {
+		t.Errorf("ScoredMovie is encoded as %s", bts)
+	}
+
+	var got ScoredMovie
+	if err := json.Unmarshal(bts, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Runtime != 128 || got.Score != 4.5 || got.EditDistance != 1 {
+		t.Errorf("Decoded %s into %+v", bts, got)
+	}
+}
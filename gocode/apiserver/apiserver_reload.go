@@ -0,0 +1,137 @@
+// Hot reloading of the API data. The server periodically asks MongoDB for the time of the
+// latest data set and, if it is newer than the data that is being served, downloads it and
+// builds the search trie and the k-d tree off to the side. The new data and indexes are then
+// swapped in with a single atomic store so that in-flight requests never see a half-built index.
+// Sending SIGHUP to the process triggers an immediate check.
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Everything a request needs to be handled. An apiState is never modified after it is
+// created. Handlers should load the state once and use it for the rest of the request.
+type apiState struct {
+	Data      *sfmovies.APIData
+	Trie      *TrieNode
+	Spatial   *KDTree
+	Facets    map[string]*movieFacets
+	People    *PeopleIndex
+	Resources *ResourceIndex
+	TrieNodes int // reported by /metrics
+
+	// Unique per state, unlike the data version which is the same for states built from the
+	// same data. Used to key the response cache.
+	Generation uint64
+}
+
+// The number of states created.
+var generations atomic.Uint64
+
+// The key of the state in the context of a request.
+type stateKey struct{}
+
+// Returns r with st as its state, see requestState.
+func withState(r *http.Request, st *apiState) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), stateKey{}, st))
+}
+
+// Returns the state the request must be handled with: the state passed along with withState or
+// else the current state.
+func requestState(r *http.Request) *apiState {
+	if st, ok := r.Context().Value(stateKey{}).(*apiState); ok {
+		return st
+	}
+	return state.Load()
+}
+
+// Builds the indexes for the given data.
+func newAPIState(ad *sfmovies.APIData) *apiState {
+	trie := CreateTrie(ad)
+	return &apiState{
+		Data:      ad,
+		Trie:      trie,
+		Spatial:   NewKDTree(sceneList(ad)),
+		Facets:    newMovieFacets(ad),
+		People:    NewPeopleIndex(ad),
+		Resources: NewResourceIndex(ad),
+		TrieNodes: trie.Size(),
+
+		Generation: generations.Add(1),
+	}
+}
+
+// Returns the scenes of ad as a slice.
+func sceneList(ad *sfmovies.APIData) []*sfmovies.Scene {
+	scs := make([]*sfmovies.Scene, 0, len(ad.Scenes))
+	for _, scene := range ad.Scenes {
+		scs = append(scs, scene)
+	}
+	return scs
+}
+
+// Checks for newer data every interval and whenever a SIGHUP is received. Never returns.
+func watchAPIData(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-hup:
+			log.Println("received SIGHUP, checking for new API data")
+		}
+		if err := reloadAPIData(); err != nil {
+			log.Println("reloading API data failed:", err)
+		}
+	}
+}
+
+// Downloads the latest APIData if it is newer than the data currently being served.
+func reloadAPIData() error {
+	latest, err := sfmovies.GetLatestAPIDataTime()
+	if err != nil {
+		return err
+	}
+	if !latest.After(state.Load().Data.Time) {
+		return nil
+	}
+
+	ad, err := sfmovies.GetLatestAPIData()
+	if err != nil {
+		return err
+	}
+	if swapAPIData(ad) {
+		log.Println("now serving API data version", ad.Time)
+	}
+	return nil
+}
+
+// Builds a new state for ad and swaps it in if ad is newer than the data currently served.
+// Returns true if the swap happened.
+func swapAPIData(ad *sfmovies.APIData) bool {
+	next := newAPIState(ad)
+	for {
+		cur := state.Load()
+		if cur != nil && !ad.Time.After(cur.Data.Time) {
+			return false
+		}
+		if state.CompareAndSwap(cur, next) {
+			// the cached responses belong to the old data
+			responses.Purge()
+			return true
+		}
+	}
+}
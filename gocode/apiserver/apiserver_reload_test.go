@@ -0,0 +1,42 @@
+// Tests for apiserver_reload.go
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+func TestSwapAPIData(t *testing.T) {
+	defer state.Store(state.Load())
+
+	t0 := time.Date(2015, 3, 1, 4, 0, 0, 0, time.UTC)
+	old := sfmovies.NewAPIData()
+	old.Time = t0
+	state.Store(newAPIState(old))
+
+	cases := []struct {
+		t       time.Time
+		swapped bool
+	}{
+		{t0.Add(-time.Hour), false},
+		{t0, false},
+		{t0.Add(24 * time.Hour), true},
+		{t0.Add(time.Hour), false},
+	}
+	for _, c := range cases {
+		ad := sfmovies.NewAPIData()
+		ad.Time = c.t
+		got := swapAPIData(ad)
+		if got != c.swapped {
+			t.Errorf("swapAPIData(%v) == %v, want %v", c.t, got, c.swapped)
+		}
+		if got && state.Load().Data != ad {
+			t.Errorf("swapAPIData(%v) did not store the new data", c.t)
+		}
+	}
+	if v := state.Load().Data.Time; !v.Equal(t0.Add(24 * time.Hour)) {
+		t.Errorf("Serving data version %v, want %v", v, t0.Add(24*time.Hour))
+	}
+}
@@ -0,0 +1,140 @@
+// Resource endpoints for scenes and locations. Scenes are identified by the FNV hash dbupdate
+// computes for them, which is the key of the scene in APIData.Scenes. Locations are identified
+// by a hash of their coordinates, so all scenes that were geocoded to the same place share a
+// location, whatever name the source table uses for it.
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// A scene with its ID and the ID of its location. Movie is only set when a single scene is requested.
+type SceneInfo struct {
+	ID string
+	*sfmovies.Scene
+	LocationID string
+	Movie      *sfmovies.Movie `json:",omitempty"`
+}
+
+// A geocoded place with every movie that was filmed there. Returned by /locations/{id}.
+type LocationInfo struct {
+	ID     string
+	Lat    float64
+	Lng    float64
+	Names  []string // the location names of the scenes, sorted
+	Movies []*sfmovies.Movie
+	Scenes []*SceneInfo
+}
+
+type ResourceIndex struct {
+	scenes      map[string]*SceneInfo
+	byScene     map[*sfmovies.Scene]*SceneInfo
+	movieScenes map[string][]*SceneInfo
+	locations   map[string]*LocationInfo
+}
+
+// Returns the ID of the place at the coordinates of loc.
+func LocationID(loc *sfmovies.Location) string {
+	hasher := fnv.New32()
+	hasher.Write([]byte(strconv.FormatFloat(loc.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(loc.Lng, 'f', -1, 64)))
+	return fmt.Sprintf("%x", hasher.Sum32())
+}
+
+// Groups the scenes by movie and by location.
+func NewResourceIndex(ad *sfmovies.APIData) *ResourceIndex {
+	idx := &ResourceIndex{
+		scenes:      make(map[string]*SceneInfo),
+		byScene:     make(map[*sfmovies.Scene]*SceneInfo),
+		movieScenes: make(map[string][]*SceneInfo),
+		locations:   make(map[string]*LocationInfo),
+	}
+	for id, scene := range ad.Scenes {
+		if scene.Location == nil {
+			continue
+		}
+		info := &SceneInfo{ID: id, Scene: scene, LocationID: LocationID(scene.Location)}
+		idx.scenes[id] = info
+		idx.byScene[scene] = info
+		idx.movieScenes[scene.IMDBID] = append(idx.movieScenes[scene.IMDBID], info)
+
+		loc, ok := idx.locations[info.LocationID]
+		if !ok {
+			loc = &LocationInfo{
+				ID:     info.LocationID,
+				Lat:    scene.Lat,
+				Lng:    scene.Lng,
+				Names:  []string{},
+				Movies: []*sfmovies.Movie{},
+			}
+			idx.locations[info.LocationID] = loc
+		}
+		loc.Scenes = append(loc.Scenes, info)
+	}
+
+	for _, scs := range idx.movieScenes {
+		sortSceneInfos(scs)
+	}
+	for _, loc := range idx.locations {
+		sortSceneInfos(loc.Scenes)
+		for _, info := range loc.Scenes {
+			if !contains(loc.Names, info.Name) {
+				loc.Names = append(loc.Names, info.Name)
+			}
+			// the scenes are sorted by movie, so the scenes of a movie are next to each other
+			movie, ok := ad.Movies[info.IMDBID]
+			if n := len(loc.Movies); ok && (n == 0 || loc.Movies[n-1] != movie) {
+				loc.Movies = append(loc.Movies, movie)
+			}
+		}
+		sort.Strings(loc.Names)
+	}
+	return idx
+}
+
+// Sorts scenes by movie, location name and ID so they are always listed in the same order.
+func sortSceneInfos(scs []*SceneInfo) {
+	sort.Slice(scs, func(i, j int) bool {
+		a, b := scs[i], scs[j]
+		if a.IMDBID != b.IMDBID {
+			return a.IMDBID < b.IMDBID
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+}
+
+// Returns the scene with the ID and its movie, or nil if there is none.
+func (idx *ResourceIndex) Scene(id string, ad *sfmovies.APIData) *SceneInfo {
+	info, ok := idx.scenes[id]
+	if !ok {
+		return nil
+	}
+	withMovie := *info
+	withMovie.Movie = ad.Movies[info.IMDBID]
+	return &withMovie
+}
+
+// Returns the IDs of the scene, or nil if the scene has no location.
+func (idx *ResourceIndex) SceneInfo(scene *sfmovies.Scene) *SceneInfo {
+	return idx.byScene[scene]
+}
+
+// Returns the scenes of the movie with the IMDB ID.
+func (idx *ResourceIndex) MovieScenes(imdbid string) []*SceneInfo {
+	if scs, ok := idx.movieScenes[imdbid]; ok {
+		return scs
+	}
+	return []*SceneInfo{}
+}
+
+// Returns the location with the ID, or nil if there is none.
+func (idx *ResourceIndex) Location(id string) *LocationInfo {
+	return idx.locations[id]
+}
@@ -0,0 +1,137 @@
+// Tests for apiserver_resources.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Creates APIData where two movies were filmed at City Hall, under different location names.
+func resourcesTestData() *sfmovies.APIData {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt0066999"] = &sfmovies.Movie{IMDBID: "tt0066999", Title: "Dirty Harry"}
+	ad.Movies["tt0077745"] = &sfmovies.Movie{IMDBID: "tt0077745", Title: "Invasion of the Body Snatchers"}
+	cityHall := sfmovies.Location{Lat: 37.7792597, Lng: -122.4192646}
+	scenes := []struct {
+		id, imdbid, name string
+		loc              sfmovies.Location
+	}{
+		{"a1", "tt0066999", "City Hall", cityHall},
+		{"a2", "tt0066999", "Kezar Stadium", sfmovies.Location{Lat: 37.7670, Lng: -122.4563}},
+		{"b1", "tt0077745", "San Francisco City Hall", cityHall},
+	}
+	for _, s := range scenes {
+		loc := s.loc
+		loc.Name = s.name
+		ad.Scenes[s.id] = &sfmovies.Scene{IMDBID: s.imdbid, Location: &loc}
+	}
+	return ad
+}
+
+func TestLocationID(t *testing.T) {
+	a := LocationID(&sfmovies.Location{Name: "City Hall", Lat: 37.7792597, Lng: -122.4192646})
+	b := LocationID(&sfmovies.Location{Name: "San Francisco City Hall", Lat: 37.7792597, Lng: -122.4192646})
+	c := LocationID(&sfmovies.Location{Name: "City Hall", Lat: 37.7792598, Lng: -122.4192646})
+	if a != b {
+		t.Errorf("Locations with the same coordinates have IDs %v and %v", a, b)
+	}
+	if a == c {
+		t.Errorf("Locations with different coordinates have the same ID %v", a)
+	}
+}
+
+func TestResourceIndex(t *testing.T) {
+	ad := resourcesTestData()
+	idx := NewResourceIndex(ad)
+
+	var ids []string
+	for _, scene := range idx.MovieScenes("tt0066999") {
+		ids = append(ids, scene.ID)
+	}
+	if want := []string{"a1", "a2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("MovieScenes(%q) == %v, want %v", "tt0066999", ids, want)
+	}
+	if scs := idx.MovieScenes("tt0000000"); scs == nil || len(scs) != 0 {
+		t.Errorf("MovieScenes(%q) == %v, want an empty list", "tt0000000", scs)
+	}
+
+	scene := idx.Scene("b1", ad)
+	if scene == nil || scene.Movie != ad.Movies["tt0077745"] || scene.Name != "San Francisco City Hall" {
+		t.Fatalf("Scene(%q) == %+v", "b1", scene)
+	}
+	if idx.scenes["b1"].Movie != nil {
+		t.Errorf("Scene(%q) modified the index", "b1")
+	}
+
+	loc := idx.Location(scene.LocationID)
+	if loc == nil {
+		t.Fatalf("Location(%q) == nil", scene.LocationID)
+	}
+	if want := []string{"City Hall", "San Francisco City Hall"}; !reflect.DeepEqual(loc.Names, want) {
+		t.Errorf("Names == %v, want %v", loc.Names, want)
+	}
+	if len(loc.Movies) != 2 || loc.Movies[0].Title != "Dirty Harry" || len(loc.Scenes) != 2 {
+		t.Errorf("Location(%q) == %+v, want both movies and scenes", scene.LocationID, loc)
+	}
+	if idx.Location("x") != nil {
+		t.Errorf("Location(%q) != nil", "x")
+	}
+}
+
+func TestResourceHandlers(t *testing.T) {
+	ad := resourcesTestData()
+	defer serveTestData(ad)()
+
+	w := httptest.NewRecorder()
+	moviesHandler(w, httptest.NewRequest("GET", "/movies/tt0066999/scenes", nil))
+	var res SceneResults
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	scenes := res.Scenes
+	if len(scenes) != 2 || res.Total != 2 || scenes[0].ID != "a1" || scenes[0].Name != "City Hall" || scenes[0].LocationID == "" || scenes[0].Movie != nil {
+		t.Errorf("/movies/tt0066999/scenes returned %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	scenesHandler(w, httptest.NewRequest("GET", "/scenes/a2", nil))
+	var scene SceneInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &scene); err != nil {
+		t.Fatal(err)
+	}
+	if scene.ID != "a2" || scene.Movie == nil || scene.Movie.Title != "Dirty Harry" {
+		t.Errorf("/scenes/a2 returned %v", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	locationsHandler(w, httptest.NewRequest("GET", "/locations/"+scenes[0].LocationID, nil))
+	var loc LocationInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &loc); err != nil {
+		t.Fatal(err)
+	}
+	if len(loc.Movies) != 2 || loc.Movies[1].Title != "Invasion of the Body Snatchers" {
+		t.Errorf("/locations/%v returned %v", scenes[0].LocationID, w.Body.String())
+	}
+
+	notFound := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/movies/tt0000000/scenes", moviesHandler},
+		{"/scenes/x", scenesHandler},
+		{"/locations/x", locationsHandler},
+	}
+	for _, c := range notFound {
+		w = httptest.NewRecorder()
+		c.handler(w, httptest.NewRequest("GET", c.path, nil))
+		var e Error
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || w.Code != http.StatusNotFound || e.Error == nil || e.Error.Code != codeNotFound {
+			t.Errorf("%v returned %v %v, want a not found error", c.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// In-process cache of serialized responses. Search and auto-complete results only depend on
+// the query and the data version, so the responses to hot queries are kept in a least recently
+// used cache, keyed by the data version, the canonical query and the Accept header. The cache
+// holds at most --response-cache bytes of responses and is purged when newer data is swapped in.
+// Concurrent requests for the same query that is not cached yet are coalesced: the query is
+// computed once and every request gets the response (single-flight).
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"flag"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+var responseCacheSize = flag.Int("response-cache", 64<<20, "maximum size in bytes of the cached search and auto-complete responses, 0 disables the cache")
+
+// The responses of search and auto-complete queries, see rootHandler.
+var responses = NewResponseCache(*responseCacheSize)
+
+// The statistics of a ResponseCache. Reported by /status.
+type ResponseCacheStats struct {
+	Hits      int64 // responses served from the cache
+	Misses    int64 // responses computed
+	Coalesced int64 // responses that waited for an identical request to compute them
+	Entries   int
+	Bytes     int
+}
+
+// A response as written by a handler. Add holds the values the handler added to headers
+// that were already set, Set the headers it replaced.
+type cachedResponse struct {
+	key    string
+	status int
+	set    http.Header
+	add    http.Header
+	body   []byte
+}
+
+// The size of the response in the cache, headers are small enough to be ignored.
+func (c *cachedResponse) size() int {
+	return len(c.key) + len(c.body)
+}
+
+// Writes the response as if the handler wrote it.
+func (c *cachedResponse) write(w http.ResponseWriter) {
+	for k, vs := range c.set {
+		w.Header()[k] = vs
+	}
+	for k, vs := range c.add {
+		w.Header()[k] = append(w.Header()[k], vs...)
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body)
+}
+
+// A computation of a response that other requests can wait for.
+type flight struct {
+	done chan struct{}
+	resp *cachedResponse
+}
+
+type ResponseCache struct {
+	mu      sync.Mutex
+	max     int
+	size    int
+	lru     *list.List // of *cachedResponse, most recently used first
+	entries map[string]*list.Element
+	flights map[string]*flight
+
+	hits, misses, coalesced atomic.Int64
+}
+
+// Creates a cache that holds at most max bytes of responses. The cache is disabled if max is 0.
+func NewResponseCache(max int) *ResponseCache {
+	return &ResponseCache{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		flights: make(map[string]*flight),
+	}
+}
+
+// Returns the key of the response to the request for the state. The key holds the generation of
+// the state rather than the data version, which is the same for states built from the same data.
+func responseKey(r *http.Request, st *apiState) string {
+	return strconv.FormatUint(st.Generation, 36) + " " + canonicalQuery(r) + " " + r.Header.Get("Accept")
+}
+
+// Serves the request from the cache, or with fn if it is not cached. Only successful
+// responses are cached. The state is loaded once and passed to fn, see requestState, so the
+// response always belongs to the state of its key.
+func (c *ResponseCache) Serve(w http.ResponseWriter, r *http.Request, fn Handler) {
+	if c.max <= 0 {
+		fn(w, r)
+		return
+	}
+	st := state.Load()
+	r = withState(r, st)
+	key := responseKey(r, st)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		e.Value.(*cachedResponse).write(w)
+		return
+	}
+	if f, ok := c.flights[key]; ok {
+		c.mu.Unlock()
+		<-f.done
+		if f.resp == nil {
+			// the computation panicked, compute the response without the cache
+			fn(w, r)
+			return
+		}
+		c.coalesced.Add(1)
+		f.resp.write(w)
+		return
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	c.compute(f, st, key, w, r, fn).write(w)
+}
+
+// Computes the response of the flight with fn and caches it if it is successful and st is still
+// the current state, a response of an older state would stay in the cache after it was purged.
+// The flight is removed even if fn panics, the requests that wait for it then compute the
+// response themselves.
+func (c *ResponseCache) compute(f *flight, st *apiState, key string, w http.ResponseWriter, r *http.Request, fn Handler) *cachedResponse {
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		if f.resp != nil && f.resp.status == http.StatusOK && state.Load() == st {
+			c.add(f.resp)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	resp := record(w, r, fn)
+	resp.key = key
+	f.resp = resp
+	return resp
+}
+
+// Adds the response and evicts the least recently used responses until the cache fits.
+// Responses that are larger than the cache are not added. c.mu must be held.
+func (c *ResponseCache) add(resp *cachedResponse) {
+	if resp.size() > c.max {
+		return
+	}
+	if e, ok := c.entries[resp.key]; ok {
+		c.remove(e)
+	}
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	c.size += resp.size()
+	for c.size > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// c.mu must be held.
+func (c *ResponseCache) remove(e *list.Element) {
+	resp := c.lru.Remove(e).(*cachedResponse)
+	delete(c.entries, resp.key)
+	c.size -= resp.size()
+}
+
+// Removes every response. Computations that are in flight still finish.
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
+
+func (c *ResponseCache) Stats() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ResponseCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Coalesced: c.coalesced.Load(),
+		Entries:   c.lru.Len(),
+		Bytes:     c.size,
+	}
+}
+
+// Runs fn and returns what it wrote. The headers fn sets are compared with the headers of w
+// before fn ran, so the headers set by the handlers around fn (e.g. CORS) are not cached.
+func record(w http.ResponseWriter, r *http.Request, fn Handler) *cachedResponse {
+	rec := &responseRecorder{header: w.Header().Clone(), status: http.StatusOK}
+	fn(rec, r)
+
+	resp := &cachedResponse{status: rec.status, set: http.Header{}, add: http.Header{}, body: rec.body.Bytes()}
+	before := w.Header()
+	for k, vs := range rec.header {
+		prev := before[k]
+		switch {
+		case slices.Equal(vs, prev):
+		case len(vs) > len(prev) && slices.Equal(vs[:len(prev)], prev):
+			resp.add[k] = vs[len(prev):]
+		default:
+			resp.set[k] = vs
+		}
+	}
+	return resp
+}
+
+// Records the response of a handler.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(p)
+}
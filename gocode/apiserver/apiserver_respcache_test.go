@@ -0,0 +1,222 @@
+// Tests for apiserver_respcache.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves path through the cache with fn and returns the response.
+func serveCached(c *ResponseCache, fn Handler, path string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	for i := 0; i < len(header); i += 2 {
+		w.Header().Set(header[i], header[i+1])
+	}
+	c.Serve(w, r, fn)
+	return w
+}
+
+func TestResponseCache(t *testing.T) {
+	defer serveTestData(testAPIData(50))()
+	c := NewResponseCache(1 << 20)
+
+	first := serveCached(c, searchHandler, "/search?q=movie&format=geojson", "Access-Control-Allow-Origin", "http://a.org")
+	second := serveCached(c, searchHandler, "/search?format=geojson&q=movie", "Access-Control-Allow-Origin", "http://b.org")
+	if first.Body.String() != second.Body.String() || second.Code != http.StatusOK {
+		t.Errorf("Cached response differs from the computed response")
+	}
+	if ct := second.Header().Get("Content-Type"); ct != geoJSONType {
+		t.Errorf("Cached response has Content-Type %q, want %q", ct, geoJSONType)
+	}
+	if vary := second.Header().Values("Vary"); len(vary) != 1 || vary[0] != "Accept" {
+		t.Errorf("Cached response has Vary %v", vary)
+	}
+	if origin := second.Header().Get("Access-Control-Allow-Origin"); origin != "http://b.org" {
+		t.Errorf("Cached response replaced the header of the request with %q", origin)
+	}
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 1 || s.Entries != 1 || s.Bytes == 0 {
+		t.Errorf("Stats are %+v after a miss and a hit", s)
+	}
+
+	// errors and other queries are not served from the cache
+	serveCached(c, searchHandler, "/search?q=movie")
+	serveCached(c, searchHandler, "/search?q=")
+	serveCached(c, searchHandler, "/search?q=")
+	if s := c.Stats(); s.Hits != 1 || s.Misses != 4 || s.Entries != 2 {
+		t.Errorf("Stats are %+v, want 1 hit, 4 misses and 2 entries", s)
+	}
+
+	// newer data purges the cache
+	prev := responses
+	responses = c
+	defer func() { responses = prev }()
+	newer := testAPIData(50)
+	newer.Time = state.Load().Data.Time.Add(time.Hour)
+	swapAPIData(newer)
+	if s := c.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("Stats are %+v after a data swap", s)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	defer serveTestData(testAPIData(10))()
+	body := make([]byte, 100)
+	fn := func(w http.ResponseWriter, r *http.Request) { w.Write(body) }
+	// room for three responses, their keys are about 30 bytes
+	c := NewResponseCache(450)
+
+	for i := 0; i < 3; i++ {
+		serveCached(c, fn, "/complete?term="+strconv.Itoa(i))
+	}
+	serveCached(c, fn, "/complete?term=0") // term=1 is now the least recently used
+	serveCached(c, fn, "/complete?term=3")
+	if s := c.Stats(); s.Entries != 3 || s.Bytes > 450 {
+		t.Errorf("Stats are %+v, want 3 entries of at most 450 bytes", s)
+	}
+	for _, tc := range []struct {
+		term   string
+		cached bool
+	}{{"0", true}, {"2", true}, {"3", true}, {"1", false}} {
+		hits := c.Stats().Hits
+		serveCached(c, fn, "/complete?term="+tc.term)
+		if got := c.Stats().Hits > hits; got != tc.cached {
+			t.Errorf("term=%v was cached: %v, want %v", tc.term, got, tc.cached)
+		}
+	}
+
+	disabled := NewResponseCache(0)
+	serveCached(disabled, fn, "/complete?term=0")
+	serveCached(disabled, fn, "/complete?term=0")
+	if s := disabled.Stats(); s.Hits != 0 || s.Entries != 0 {
+		t.Errorf("Disabled cache has stats %+v", s)
+	}
+}
+
+func TestResponseCacheSingleFlight(t *testing.T) {
+	defer serveTestData(testAPIData(10))()
+	c := NewResponseCache(1 << 20)
+
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		w.Write([]byte("result"))
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = serveCached(c, fn, "/search?q=movie").Body.String()
+		}(i)
+	}
+	// give the requests time to join the flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Concurrent identical requests computed the response %v times", calls)
+	}
+	for i, body := range bodies {
+		if body != "result" {
+			t.Errorf("Request %v got %q", i, body)
+		}
+	}
+	if s := c.Stats(); s.Misses != 1 || s.Hits+s.Coalesced != 9 {
+		t.Errorf("Stats are %+v, want 1 miss and 9 hits or coalesced requests", s)
+	}
+}
+
+// A handler that panics must not leave its flight behind, or identical requests would block forever.
+func TestResponseCachePanic(t *testing.T) {
+	defer serveTestData(testAPIData(10))()
+	c := NewResponseCache(1 << 20)
+
+	var mu sync.Mutex
+	calls := 0
+	release := make(chan struct{})
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if first {
+			<-release
+			panic("handler failed")
+		}
+		w.Write([]byte("result"))
+	}
+
+	done := make(chan string, 3)
+	go func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("The panic of the handler was not re-raised")
+			}
+			done <- "panicked"
+		}()
+		serveCached(c, fn, "/search?q=movie")
+	}()
+	// wait for the first request to start the flight
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		go func() { done <- serveCached(c, fn, "/search?q=movie").Body.String() }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	bodies := map[string]int{}
+	for i := 0; i < 3; i++ {
+		select {
+		case body := <-done:
+			bodies[body]++
+		case <-time.After(time.Second):
+			t.Fatal("Requests are blocked by the flight of a handler that panicked")
+		}
+	}
+	if bodies["panicked"] != 1 || bodies["result"] != 2 {
+		t.Errorf("Requests returned %v", bodies)
+	}
+	if body := serveCached(c, fn, "/search?q=movie").Body.String(); body != "result" {
+		t.Errorf("Request after the panic returned %q", body)
+	}
+}
+
+// States with the same data version must not share responses, and a response computed while
+// newer data is swapped in must not be cached.
+func TestResponseCacheState(t *testing.T) {
+	defer serveTestData(testAPIData(5))()
+	c := NewResponseCache(1 << 20)
+	small := serveCached(c, searchHandler, "/search?q=movie").Body.String()
+
+	serveTestData(testAPIData(10))
+	if large := serveCached(c, searchHandler, "/search?q=movie").Body.String(); large == small {
+		t.Errorf("Response of another state with the same data version was served from the cache")
+	}
+
+	swapped := false
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !swapped {
+			swapped = true
+			serveTestData(testAPIData(20))
+		}
+		searchHandler(w, r)
+	}
+	before := c.Stats().Entries
+	serveCached(c, fn, "/search?q=movie&limit=3")
+	if s := c.Stats(); s.Entries != before {
+		t.Errorf("Response computed during a swap was cached, stats are %+v", s)
+	}
+}
@@ -0,0 +1,69 @@
+// Graceful shutdown of the API server. On SIGTERM or SIGINT (e.g. docker restart) the server
+// reports that it is draining on "/status" with 503 Service Unavailable, while it keeps serving
+// every other request for the drain delay. The load balancer and the monitor need an open
+// connection to see the 503, otherwise they would get connection refused and restart the server.
+// After the drain delay the server stops accepting new connections and waits for outstanding
+// requests to finish. Requests that take longer than the grace period are cut off.
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Set when the server is shutting down.
+var draining atomic.Bool
+
+// Serves requests on srv.Addr until SIGTERM or SIGINT is received and then shuts down srv gracefully.
+func serve(srv *http.Server, drain, grace time.Duration) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	return serveListener(srv, ln, drain, grace)
+}
+
+// Serves requests on ln until SIGTERM or SIGINT is received and then shuts down srv gracefully.
+// Returns when all outstanding requests are finished or the grace period has passed.
+func serveListener(srv *http.Server, ln net.Listener, drain, grace time.Duration) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sig)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case s := <-sig:
+		log.Println("received", s, "draining connections")
+	}
+	return shutdown(srv, drain, grace)
+}
+
+// Flips the server into the draining state, keeps serving for the drain delay and then waits
+// at most grace for outstanding requests.
+func shutdown(srv *http.Server, drain, grace time.Duration) error {
+	draining.Store(true)
+	srv.SetKeepAlivesEnabled(false)
+	time.Sleep(drain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		log.Println("grace period exceeded, closing remaining connections")
+		return srv.Close()
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+// Tests for apiserver_shutdown.go
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	defer draining.Store(false)
+
+	started := make(chan bool)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+	<-started
+
+	if err := shutdown(srv, 0, time.Second); err != nil {
+		t.Errorf("shutdown returned %v", err)
+	}
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("In-flight request got status %v, want %v", code, http.StatusOK)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Errorf("Server still accepts connections after shutdown")
+	}
+}
+
+func TestStatusWhileDraining(t *testing.T) {
+	defer serveTestData(sfmovies.NewAPIData())()
+	defer draining.Store(false)
+
+	cases := []struct {
+		draining bool
+		code     int
+	}{
+		{false, http.StatusOK},
+		{true, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		draining.Store(c.draining)
+		w := httptest.NewRecorder()
+		statusHandler(w, httptest.NewRequest("GET", "/status", nil))
+		if w.Code != c.code {
+			t.Errorf("statusHandler while draining=%v returned %v, want %v", c.draining, w.Code, c.code)
+		}
+	}
+}
+
+// After SIGTERM the server must keep accepting connections for the drain delay, so that clients
+// see /status answer 503 instead of connection refused.
+func TestDrainAfterSIGTERM(t *testing.T) {
+	defer serveTestData(sfmovies.NewAPIData())()
+	defer draining.Store(false)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/", rootHandler)
+	srv := &http.Server{Handler: mux}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serveListener(srv, ln, 500*time.Millisecond, time.Second)
+	}()
+
+	base := "http://" + ln.Addr().String()
+	get := func(path string) int {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// the signal handler is installed once the server answers
+	for i := 0; get("/status") != http.StatusOK; i++ {
+		if i == 100 {
+			t.Fatal("Server didn't start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; !draining.Load(); i++ {
+		if i == 100 {
+			t.Fatal("Server didn't start draining after SIGTERM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := get("/status"); code != http.StatusServiceUnavailable {
+		t.Errorf("/status while draining returned %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	if code := get("/"); code != http.StatusOK {
+		t.Errorf("/ while draining returned %v, want %v", code, http.StatusOK)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("serveListener returned %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Server didn't shut down after the drain delay")
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Errorf("Server still accepts connections after shutdown")
+	}
+}
@@ -0,0 +1,255 @@
+// Implementation of a k-d tree that stores scenes by their coordinates. The tree is used
+// for location based queries. Each level of the tree alternately splits the scenes on
+// latitude and longitude at the median, so the tree is balanced and a nearest neighbour
+// query only has to visit the branches that can contain points closer than the ones already found.
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+const (
+	splitLat = iota
+	splitLng
+)
+
+type KDTree struct {
+	root *kdNode
+	size int
+	// The largest absolute latitude in the tree. Used to bound distances in longitudinal direction.
+	maxAbsLat float64
+}
+
+type kdNode struct {
+	scene       *sfmovies.Scene
+	left, right *kdNode
+	axis        int
+}
+
+// Builds a balanced k-d tree from the scenes.
+func NewKDTree(scenes []*sfmovies.Scene) *KDTree {
+	t := new(KDTree)
+	scs := make([]*sfmovies.Scene, 0, len(scenes))
+	for _, scene := range scenes {
+		if scene.Location == nil {
+			continue
+		}
+		scs = append(scs, scene)
+		t.maxAbsLat = math.Max(t.maxAbsLat, math.Abs(scene.Lat))
+	}
+	t.size = len(scs)
+	t.root = buildKDNode(scs, splitLat)
+	return t
+}
+
+// Recursively builds the subtree for scs. The scenes are split at the median of the axis.
+func buildKDNode(scs []*sfmovies.Scene, axis int) *kdNode {
+	if len(scs) == 0 {
+		return nil
+	}
+	sort.Slice(scs, func(i, j int) bool {
+		return coord(scs[i].Location, axis) < coord(scs[j].Location, axis)
+	})
+	m := len(scs) / 2
+	next := (axis + 1) % 2
+	return &kdNode{
+		scene: scs[m],
+		axis:  axis,
+		left:  buildKDNode(scs[:m], next),
+		right: buildKDNode(scs[m+1:], next),
+	}
+}
+
+// Returns the latitude or longitude of loc depending on the axis.
+func coord(loc *sfmovies.Location, axis int) float64 {
+	if axis == splitLat {
+		return loc.Lat
+	}
+	return loc.Lng
+}
+
+// The number of scenes in the tree.
+func (t *KDTree) Len() int {
+	return t.size
+}
+
+// Calls fn for every scene within the bounding box spanned by min and max (inclusive) until
+// fn returns false. The scenes are always visited in the same order for the same tree.
+// Used by within handler.
+func (t *KDTree) VisitWithin(min, max *sfmovies.Location, fn func(*sfmovies.Scene) bool) {
+	t.root.visitWithin(min, max, fn)
+}
+
+// Recursively visits the subtree in order. Returns false if fn asked to stop.
+func (n *kdNode) visitWithin(min, max *sfmovies.Location, fn func(*sfmovies.Scene) bool) bool {
+	if n == nil {
+		return true
+	}
+	c := coord(n.scene.Location, n.axis)
+	if coord(min, n.axis) <= c && !n.left.visitWithin(min, max, fn) {
+		return false
+	}
+	loc := n.scene.Location
+	if min.Lat <= loc.Lat && loc.Lat <= max.Lat && min.Lng <= loc.Lng && loc.Lng <= max.Lng {
+		if !fn(n.scene) {
+			return false
+		}
+	}
+	if c <= coord(max, n.axis) && !n.right.visitWithin(min, max, fn) {
+		return false
+	}
+	return true
+}
+
+// A scene returned by a near query together with its distance to the queried location.
+type NearScene struct {
+	*sfmovies.Scene
+	DistanceMeters float64
+}
+
+// Returns at most k scenes within radius meters of loc ordered by distance, closest first.
+// Pass math.Inf(1) as radius to get the k closest scenes regardless of their distance.
+// If accept is not nil only the scenes it accepts are returned.
+// Used by near handler.
+func (t *KDTree) Nearest(loc *sfmovies.Location, k int, radius float64, accept func(*sfmovies.Scene) bool) []NearScene {
+	k = min(k, t.size)
+	if k <= 0 {
+		return []NearScene{}
+	}
+	q := t.newNearQuery(loc, k, radius, accept)
+	t.root.nearest(q)
+
+	result := make([]NearScene, len(q.h))
+	for i := len(q.h) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(&q.h).(NearScene)
+	}
+	return result
+}
+
+// Returns the number of scenes within radius meters of loc, counting at most limit scenes. If
+// accept is not nil only the scenes it accepts are counted. Used by near handler for the total
+// number of results.
+func (t *KDTree) Count(loc *sfmovies.Location, radius float64, accept func(*sfmovies.Scene) bool, limit int) int {
+	if math.IsInf(radius, 1) && accept == nil {
+		return min(t.size, limit)
+	}
+	c := 0
+	t.root.count(t.newNearQuery(loc, 0, radius, accept), &c, limit)
+	return c
+}
+
+// Recursively counts the scenes in the subtree into c until c reaches limit.
+func (n *kdNode) count(q *nearQuery, c *int, limit int) {
+	if n == nil || *c >= limit {
+		return
+	}
+	if q.loc.DistanceMeters(n.scene.Location) <= q.radius && (q.accept == nil || q.accept(n.scene)) {
+		*c++
+	}
+	delta := coord(q.loc, n.axis) - coord(n.scene.Location, n.axis)
+	near, far := n.left, n.right
+	if delta >= 0 {
+		near, far = n.right, n.left
+	}
+	near.count(q, c, limit)
+	// the other side only needs to be counted if it can contain scenes within the radius
+	if q.splitDistance(n.axis, delta) <= q.radius {
+		far.count(q, c, limit)
+	}
+}
+
+// The state of a near query while it is searching the tree. h holds the closest scenes found so far.
+type nearQuery struct {
+	loc     *sfmovies.Location
+	k       int
+	radius  float64
+	accept  func(*sfmovies.Scene) bool
+	cosProd float64
+	h       nearHeap
+}
+
+func (t *KDTree) newNearQuery(loc *sfmovies.Location, k int, radius float64, accept func(*sfmovies.Scene) bool) *nearQuery {
+	return &nearQuery{
+		loc:    loc,
+		k:      k,
+		radius: radius,
+		accept: accept,
+		// The haversine of the distance to a scene is at least cos(lat1)*cos(lat2)*hav(dLng).
+		// The cosine of the latitude of a scene is never smaller than that of the largest absolute latitude.
+		cosProd: math.Cos(loc.Lat/180*math.Pi) * math.Cos(t.maxAbsLat/180*math.Pi),
+		h:       make(nearHeap, 0, k),
+	}
+}
+
+// The distance a scene must be within to be one of the results.
+func (q *nearQuery) worst() float64 {
+	if len(q.h) < q.k {
+		return q.radius
+	}
+	return math.Min(q.radius, q.h[0].DistanceMeters)
+}
+
+// Recursively searches the subtree.
+func (n *kdNode) nearest(q *nearQuery) {
+	if n == nil {
+		return
+	}
+
+	d := q.loc.DistanceMeters(n.scene.Location)
+	if d <= q.worst() && (q.accept == nil || q.accept(n.scene)) {
+		if len(q.h) < q.k {
+			heap.Push(&q.h, NearScene{n.scene, d})
+		} else {
+			q.h[0] = NearScene{n.scene, d}
+			heap.Fix(&q.h, 0)
+		}
+	}
+
+	// search the side of the split that contains loc first
+	delta := coord(q.loc, n.axis) - coord(n.scene.Location, n.axis)
+	near, far := n.left, n.right
+	if delta >= 0 {
+		near, far = n.right, n.left
+	}
+	near.nearest(q)
+
+	// the other side only needs to be searched if it can contain closer scenes
+	if q.splitDistance(n.axis, delta) <= q.worst() {
+		far.nearest(q)
+	}
+}
+
+// A lower bound in meters on the distance from the queried location to any scene
+// on the other side of a split that is delta degrees away.
+func (q *nearQuery) splitDistance(axis int, delta float64) float64 {
+	if axis == splitLat {
+		return sfmovies.EarthRadius * math.Abs(delta) / 180 * math.Pi
+	}
+	// Longitudes wrap at the antimeridian, the other side can also be reached across it.
+	// A split west of the query has its other side between -180 and the split, which is
+	// 180-lng degrees away eastwards, a split east of it 180+lng degrees westwards.
+	wrapped := 180 - q.loc.Lng
+	if delta < 0 {
+		wrapped = 180 + q.loc.Lng
+	}
+	rad := math.Min(math.Abs(delta), math.Min(wrapped, 180)) / 180 * math.Pi
+	return 2 * sfmovies.EarthRadius * math.Asin(math.Sqrt(q.cosProd)*math.Sin(rad/2))
+}
+
+// A max-heap of scenes ordered by distance. The root is the furthest scene.
+type nearHeap []NearScene
+
+func (h nearHeap) Len() int            { return len(h) }
+func (h nearHeap) Less(i, j int) bool  { return h[i].DistanceMeters > h[j].DistanceMeters }
+func (h nearHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nearHeap) Push(x interface{}) { *h = append(*h, x.(NearScene)) }
+func (h *nearHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,268 @@
+// Tests and benchmarks for apiserver_spatial.go
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Creates n scenes at random coordinates within San Francisco.
+func randomScenes(n int, seed int64) []*sfmovies.Scene {
+	rnd := rand.New(rand.NewSource(seed))
+	scs := make([]*sfmovies.Scene, n)
+	for i := range scs {
+		scs[i] = &sfmovies.Scene{
+			IMDBID: fmt.Sprintf("tt%07d", i),
+			Location: &sfmovies.Location{
+				Lat: sfmovies.MinLat + rnd.Float64()*(sfmovies.MaxLat-sfmovies.MinLat),
+				Lng: sfmovies.MinLng + rnd.Float64()*(sfmovies.MaxLng-sfmovies.MinLng),
+			},
+		}
+	}
+	return scs
+}
+
+// The linear scan that near queries used before the k-d tree. Serves as reference.
+func nearestLinear(scenes []*sfmovies.Scene, loc *sfmovies.Location, k int) []*sfmovies.Scene {
+	ds := make([]float64, 0)
+	scs := make([]*sfmovies.Scene, 0)
+	for _, scene := range scenes {
+		ds = append(ds, loc.Distance(scene.Location))
+		scs = append(scs, scene)
+	}
+
+	result := make([]*sfmovies.Scene, 0)
+	for i := 0; i < k; i++ {
+		ix := minix(ds)
+		if ix == -1 {
+			break
+		}
+		result = append(result, scs[ix])
+
+		ds[ix] = ds[len(ds)-1]
+		scs[ix] = scs[len(scs)-1]
+		ds = ds[:len(ds)-1]
+		scs = scs[:len(scs)-1]
+	}
+	return result
+}
+
+// Returns the index of the smallest element in a.
+func minix(a []float64) int {
+	if len(a) == 0 {
+		return -1
+	}
+	mini := 0
+	for i := range a {
+		if a[i] < a[mini] {
+			mini = i
+		}
+	}
+	return mini
+}
+
+func TestNewKDTree(t *testing.T) {
+	// Empty tree
+	tree := NewKDTree(nil)
+	if tree.Len() != 0 || len(tree.Nearest(&sfmovies.Location{}, 10, math.Inf(1), nil)) != 0 {
+		t.Errorf("Empty tree returned scenes")
+	}
+
+	// Scenes without a location are skipped
+	scs := randomScenes(10, 1)
+	scs = append(scs, &sfmovies.Scene{IMDBID: "tt0000000"})
+	tree = NewKDTree(scs)
+	if tree.Len() != 10 {
+		t.Errorf("Got: %v, want %v", tree.Len(), 10)
+	}
+}
+
+// Sorts all scenes by their distance in meters and returns the first k within radius.
+func nearestSorted(scenes []*sfmovies.Scene, loc *sfmovies.Location, k int, radius float64) []NearScene {
+	all := make([]NearScene, 0)
+	for _, scene := range scenes {
+		if d := loc.DistanceMeters(scene.Location); d <= radius {
+			all = append(all, NearScene{scene, d})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].DistanceMeters < all[j].DistanceMeters })
+	if len(all) > k {
+		all = all[:k]
+	}
+	return all
+}
+
+func TestKDTreeNearest(t *testing.T) {
+	scs := randomScenes(2000, 1)
+	tree := NewKDTree(scs)
+	queries := randomScenes(100, 2)
+
+	for _, k := range []int{0, 1, 5, 20, 2000, 3000} {
+		for _, radius := range []float64{math.Inf(1), 5000, 500, 1} {
+			for _, q := range queries {
+				got := tree.Nearest(q.Location, k, radius, nil)
+				want := nearestSorted(scs, q.Location, k, radius)
+				if len(got) != len(want) {
+					t.Fatalf("Nearest(%v, %v, %v) returned %v scenes, want %v", q.Location, k, radius, len(got), len(want))
+				}
+				for i := range got {
+					if got[i].DistanceMeters != want[i].DistanceMeters {
+						t.Errorf("Nearest(%v, %v, %v)[%v] at distance %v, want %v", q.Location, k, radius, i, got[i].DistanceMeters, want[i].DistanceMeters)
+					}
+					if d := q.DistanceMeters(got[i].Location); d != got[i].DistanceMeters {
+						t.Errorf("Nearest(%v, %v, %v)[%v] reports distance %v, want %v", q.Location, k, radius, i, got[i].DistanceMeters, d)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Scenes on both sides of the antimeridian are close to each other.
+func TestKDTreeAntimeridian(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	random := func(n int) []*sfmovies.Scene {
+		scs := make([]*sfmovies.Scene, n)
+		for i := range scs {
+			lng := 175 + rnd.Float64()*5
+			if i%2 == 1 {
+				lng = -lng
+			}
+			scs[i] = &sfmovies.Scene{
+				IMDBID:   fmt.Sprintf("tt%07d", i),
+				Location: &sfmovies.Location{Lat: -5 + rnd.Float64()*10, Lng: lng},
+			}
+		}
+		return scs
+	}
+	scs := random(1000)
+	tree := NewKDTree(scs)
+	for _, q := range random(50) {
+		for _, radius := range []float64{math.Inf(1), 100000} {
+			got := tree.Nearest(q.Location, 10, radius, nil)
+			want := nearestSorted(scs, q.Location, 10, radius)
+			if len(got) != len(want) {
+				t.Fatalf("Nearest(%v, 10, %v) returned %v scenes, want %v", q.Location, radius, len(got), len(want))
+			}
+			for i := range got {
+				if got[i].DistanceMeters != want[i].DistanceMeters {
+					t.Errorf("Nearest(%v, 10, %v)[%v] at distance %v, want %v", q.Location, radius, i, got[i].DistanceMeters, want[i].DistanceMeters)
+				}
+			}
+			count := 0
+			for _, scene := range scs {
+				if q.DistanceMeters(scene.Location) <= radius {
+					count++
+				}
+			}
+			if got := tree.Count(q.Location, radius, nil, math.MaxInt); got != count {
+				t.Errorf("Count(%v, %v) == %v, want %v", q.Location, radius, got, count)
+			}
+		}
+	}
+}
+
+func BenchmarkNearLinear(b *testing.B) {
+	scs := randomScenes(100000, 1)
+	queries := randomScenes(1000, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nearestLinear(scs, queries[i%len(queries)].Location, sfmovies.NearQuerySize)
+	}
+}
+
+func BenchmarkNearKDTree(b *testing.B) {
+	tree := NewKDTree(randomScenes(100000, 1))
+	queries := randomScenes(1000, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Nearest(queries[i%len(queries)].Location, sfmovies.NearQuerySize, math.Inf(1), nil)
+	}
+}
+
+func BenchmarkNewKDTree(b *testing.B) {
+	scs := randomScenes(100000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewKDTree(scs)
+	}
+}
+
+func TestKDTreeCount(t *testing.T) {
+	scs := randomScenes(2000, 1)
+	tree := NewKDTree(scs)
+	queries := randomScenes(50, 2)
+	odd := func(scene *sfmovies.Scene) bool { return scene.IMDBID[len(scene.IMDBID)-1]%2 == 1 }
+
+	for _, radius := range []float64{math.Inf(1), 5000, 500, 1} {
+		for _, accept := range []func(*sfmovies.Scene) bool{nil, odd} {
+			for _, q := range queries {
+				want := 0
+				for _, scene := range scs {
+					if q.DistanceMeters(scene.Location) <= radius && (accept == nil || accept(scene)) {
+						want++
+					}
+				}
+				for _, limit := range []int{math.MaxInt, 100} {
+					if got := tree.Count(q.Location, radius, accept, limit); got != min(want, limit) {
+						t.Errorf("Count(%v, %v, %v) == %v, want %v", q.Location, radius, limit, got, min(want, limit))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestKDTreeVisitWithin(t *testing.T) {
+	scs := randomScenes(2000, 1)
+	tree := NewKDTree(scs)
+
+	cases := []struct {
+		min, max sfmovies.Location
+	}{
+		{sfmovies.Location{Lat: 37.7, Lng: -122.5}, sfmovies.Location{Lat: 37.8, Lng: -122.4}},
+		{sfmovies.Location{Lat: 37.75, Lng: -122.45}, sfmovies.Location{Lat: 37.751, Lng: -122.449}},
+		{sfmovies.Location{Lat: -90, Lng: -180}, sfmovies.Location{Lat: 90, Lng: 180}},
+		{sfmovies.Location{Lat: 0, Lng: 0}, sfmovies.Location{Lat: 1, Lng: 1}},
+	}
+	for _, c := range cases {
+		want := make(map[*sfmovies.Scene]bool)
+		for _, scene := range scs {
+			if c.min.Lat <= scene.Lat && scene.Lat <= c.max.Lat && c.min.Lng <= scene.Lng && scene.Lng <= c.max.Lng {
+				want[scene] = true
+			}
+		}
+		got := make(map[*sfmovies.Scene]bool)
+		tree.VisitWithin(&c.min, &c.max, func(scene *sfmovies.Scene) bool {
+			if got[scene] {
+				t.Errorf("VisitWithin(%v, %v) visited %v twice", c.min, c.max, scene.Location)
+			}
+			got[scene] = true
+			return true
+		})
+		if len(got) != len(want) {
+			t.Errorf("VisitWithin(%v, %v) visited %v scenes, want %v", c.min, c.max, len(got), len(want))
+		}
+		for scene := range got {
+			if !want[scene] {
+				t.Errorf("VisitWithin(%v, %v) visited %v outside the box", c.min, c.max, scene.Location)
+			}
+		}
+	}
+
+	// Stops when fn returns false
+	n := 0
+	all := sfmovies.Location{Lat: 90, Lng: 180}
+	tree.VisitWithin(&sfmovies.Location{Lat: -90, Lng: -180}, &all, func(scene *sfmovies.Scene) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Errorf("Got: %v, want %v", n, 10)
+	}
+}
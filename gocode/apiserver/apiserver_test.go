@@ -0,0 +1,87 @@
+// Tests for apiserver.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/CorgiMan/sfmovies/gocode"
+)
+
+// Serves ad for the duration of a test. Call the returned function to restore the previous state.
+func serveTestData(ad *sfmovies.APIData) func() {
+	prev := state.Load()
+	state.Store(newAPIState(ad))
+	return func() { state.Store(prev) }
+}
+
+// Creates APIData with n scenes spread over San Francisco.
+func testAPIData(n int) *sfmovies.APIData {
+	ad := sfmovies.NewAPIData()
+	for i, scene := range randomScenes(n, 1) {
+		ad.Scenes[scene.IMDBID] = scene
+		ad.Movies[scene.IMDBID] = &sfmovies.Movie{IMDBID: scene.IMDBID, Title: "Movie " + strconv.Itoa(i)}
+	}
+	return ad
+}
+
+func TestWithinHandler(t *testing.T) {
+	defer serveTestData(testAPIData(500))()
+
+	// Page through all scenes in San Francisco
+	seen := make(map[string]bool)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		q := url.Values{
+			"minLat": {"37.5"}, "minLng": {"-122.7"}, "maxLat": {"38"}, "maxLng": {"-122"},
+			"limit": {"60"}, "cursor": {cursor},
+		}
+		w := httptest.NewRecorder()
+		withinHandler(w, httptest.NewRequest("GET", "/within?"+q.Encode(), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("withinHandler returned %v: %v", w.Code, w.Body.String())
+		}
+		var res WithinResults
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Scenes) > 60 {
+			t.Errorf("Got %v scenes, want at most %v", len(res.Scenes), 60)
+		}
+		for _, scene := range res.Scenes {
+			if seen[scene.IMDBID] {
+				t.Errorf("Scene %v returned twice", scene.IMDBID)
+			}
+			seen[scene.IMDBID] = true
+		}
+		if res.Cursor == "" {
+			break
+		}
+		cursor = res.Cursor
+		if pages > 10 {
+			t.Fatalf("Too many pages")
+		}
+	}
+	if len(seen) != 500 {
+		t.Errorf("Got %v scenes, want %v", len(seen), 500)
+	}
+
+	// Malformed parameters
+	cases := []string{
+		"minLat=37.5&minLng=-122.7&maxLat=38",
+		"minLat=38&minLng=-122.7&maxLat=37.5&maxLng=-122",
+		"minLat=37.5&minLng=-122.7&maxLat=38&maxLng=-122&limit=0",
+		"minLat=37.5&minLng=-122.7&maxLat=38&maxLng=-122&cursor=x",
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		withinHandler(w, httptest.NewRequest("GET", "/within?"+c, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("withinHandler(%v) returned %v, want %v", c, w.Code, http.StatusBadRequest)
+		}
+	}
+}
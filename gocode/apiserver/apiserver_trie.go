@@ -5,21 +5,59 @@
 // e.g. If we traverse the trie with the string "bill". We end up with a node that has
 // scenes that are directed by Bill Guttentag, plus scenes that have the actor Bill Smitrovich
 // plus scenes that are written by Bill Walsh. If there were movies with Bill in the title those
-// would also be included.
+// would also be included. Every scene in a node also records the fields the word occurs in, so
+// the trie doubles as an index per field: "director:bill" only returns the scenes of Bill Guttentag.
+// For auto-completion the trie also stores the original display strings, e.g. the full name
+// "Bill Guttentag" is stored at the nodes "bill" and "guttentag" together with its type (director).
 package main
 
 import (
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/CorgiMan/sfmovies/gocode"
+	"golang.org/x/text/unicode/norm"
 )
 
 type TrieNode struct {
-	next   []*TrieNode
-	scenes []*sfmovies.Scene
-	letter rune
-	prev   *TrieNode
+	next    []*TrieNode // sorted by letter
+	scenes  []*sfmovies.Scene
+	fields  []Field // fields[i] holds the fields of scenes[i] in which the word occurs
+	phrases []*Completion
+	letter  rune
+	prev    *TrieNode
+}
+
+// The fields of a scene and its movie that are stored in the trie. A Field value can hold
+// multiple fields if a word occurs in more than one of them.
+type Field uint8
+
+const (
+	FieldTitle Field = 1 << iota
+	FieldYear
+	FieldWriter
+	FieldDirector
+	FieldActor
+	FieldLocation
+	FieldOther // words that were added without a field
+)
+
+// The types of the display strings stored in the trie.
+const (
+	TypeTitle    = "title"
+	TypeActor    = "actor"
+	TypeDirector = "director"
+	TypeWriter   = "writer"
+	TypeLocation = "location"
+)
+
+// A display string stored in the trie, e.g. the full name of an actor or the title of a movie.
+// Returned by the auto-complete handler.
+type Completion struct {
+	Text string
+	Type string
 }
 
 // given some APIData (a list of movies and scenes) this function construct a trie
@@ -30,13 +68,40 @@ func CreateTrie(data *sfmovies.APIData) *TrieNode {
 
 	for _, scene := range data.Scenes {
 		if movie, ok := data.Movies[scene.IMDBID]; ok {
-			root.AddMessyString(movie.Title, scene)
-			root.AddMessyString(movie.Year, scene)
-			root.AddMessyString(movie.Writer, scene)
-			root.AddMessyString(movie.Director, scene)
-			root.AddMessyString(movie.Actors, scene)
+			root.AddField(movie.Title, scene, FieldTitle)
+			root.addYears(movie.Year, scene)
+			root.addPeople(movie.Writers, scene, FieldWriter)
+			root.addPeople(movie.Directors, scene, FieldDirector)
+			root.addPeople(movie.Actors, scene, FieldActor)
+		}
+		root.AddField(scene.Name, scene, FieldLocation)
+	}
+
+	// display strings are added once, no matter how many scenes they belong to
+	added := make(map[Completion]bool)
+	add := func(text, typ string) {
+		c := Completion{text, typ}
+		if !added[c] {
+			added[c] = true
+			root.AddPhrase(&c)
+		}
+	}
+	for _, movie := range data.Movies {
+		add(movie.Title, TypeTitle)
+		for _, p := range movie.Actors {
+			add(p.Name, TypeActor)
+		}
+		for _, p := range movie.Directors {
+			add(p.Name, TypeDirector)
+		}
+		for _, p := range movie.Writers {
+			add(p.Name, TypeWriter)
+		}
+	}
+	for _, scene := range data.Scenes {
+		if scene.Location != nil {
+			add(scene.Name, TypeLocation)
 		}
-		root.AddMessyString(scene.Name, scene)
 	}
 
 	return root
@@ -44,107 +109,303 @@ func CreateTrie(data *sfmovies.APIData) *TrieNode {
 
 func NewTrieNode() *TrieNode {
 	tn := new(TrieNode)
-	tn.next = make([]*TrieNode, 36) //alphabet + digits
+	tn.next = make([]*TrieNode, 0)
 	tn.scenes = make([]*sfmovies.Scene, 0)
+	tn.fields = make([]Field, 0)
 	return tn
 }
 
 // Cleans up and splits the string and the scene to every word of the split in the trie.
 func (t *TrieNode) AddMessyString(str string, scene *sfmovies.Scene) {
+	t.AddField(str, scene, FieldOther)
+}
+
+// Adds the names of the people to the trie.
+func (t *TrieNode) addPeople(people []sfmovies.Person, scene *sfmovies.Scene, field Field) {
+	for _, p := range people {
+		t.AddField(p.Name, scene, field)
+	}
+}
+
+// Adds every year of the range to the trie, so a series that ran from 2015 to 2018 is found with
+// 2016. Open ranges of series that are still running only add their first year.
+func (t *TrieNode) addYears(yr sfmovies.YearRange, scene *sfmovies.Scene) {
+	if yr.From == 0 {
+		return
+	}
+	for year := yr.From; year <= max(yr.From, yr.To); year++ {
+		t.addScene(strconv.Itoa(year), scene, FieldYear)
+	}
+}
+
+// Like AddMessyString, but also records the field of the scene the string comes from.
+// The fields are used to rank search results.
+func (t *TrieNode) AddField(str string, scene *sfmovies.Scene, field Field) {
 	str = CleanString(str)
 	split := strings.Fields(str)
 	for _, word := range split {
-		t.Add(word, scene)
+		t.addScene(word, scene, field)
 	}
 }
 
 // Add a scene in the trie located at str.
 func (t *TrieNode) Add(str string, scene *sfmovies.Scene) {
 	str = CleanString(str)
-	t.recursiveAdd(str, scene)
+	t.addScene(str, scene, FieldOther)
 }
 
-// Recursively traverse the try with str and append the scene to that node's scenes.
-func (t *TrieNode) recursiveAdd(str string, scene *sfmovies.Scene) {
-	if len(str) == 0 {
-		t.scenes = append(t.scenes, scene)
+// Traverse the try with str and append the scene to that node's scenes.
+// If the scene was the last one added to the node only the field is recorded.
+func (t *TrieNode) addScene(str string, scene *sfmovies.Scene, field Field) {
+	n := t.addNode(str)
+	if last := len(n.scenes) - 1; last >= 0 && n.scenes[last] == scene {
+		n.fields[last] |= field
 		return
 	}
-	if ix, ok := toIndex(str[0]); ok {
-		if t.next[ix] == nil {
-			t.next[ix] = NewTrieNode()
-			t.next[ix].prev = t
-			t.next[ix].letter = rune(str[0])
+	n.scenes = append(n.scenes, scene)
+	n.fields = append(n.fields, field)
+}
+
+// Stores the display string at the node of every word in it.
+func (t *TrieNode) AddPhrase(c *Completion) {
+	for _, word := range strings.Fields(CleanString(c.Text)) {
+		n := t.addNode(word)
+		// the word may occur more than once in the string
+		if len(n.phrases) > 0 && n.phrases[len(n.phrases)-1] == c {
+			continue
 		}
-		t.next[ix].recursiveAdd(str[1:], scene)
+		n.phrases = append(n.phrases, c)
 	}
 }
 
-// The results of a search query
-type SearchResults struct {
-	Movies []*sfmovies.Movie
-	Scenes []*sfmovies.Scene
+// Returns the node located at str, creating the nodes on the way if they don't exist.
+func (t *TrieNode) addNode(str string) *TrieNode {
+	n := t
+	for _, r := range str {
+		n = n.addChild(r)
+	}
+	return n
 }
 
-// Traverse the trie with str and lists the scenes stored at this node.
-// From these scenes a list of movies is composed which is also part of the result.
+// Returns the child node for the letter or nil if it doesn't exist.
+func (t *TrieNode) child(letter rune) *TrieNode {
+	i := t.search(letter)
+	if i < len(t.next) && t.next[i].letter == letter {
+		return t.next[i]
+	}
+	return nil
+}
+
+// Returns the child node for the letter, creating it if it doesn't exist.
+func (t *TrieNode) addChild(letter rune) *TrieNode {
+	i := t.search(letter)
+	if i < len(t.next) && t.next[i].letter == letter {
+		return t.next[i]
+	}
+	n := NewTrieNode()
+	n.prev = t
+	n.letter = letter
+	t.next = append(t.next, nil)
+	copy(t.next[i+1:], t.next[i:])
+	t.next[i] = n
+	return n
+}
+
+// Returns the index in next at which the child for the letter is or should be.
+func (t *TrieNode) search(letter rune) int {
+	return sort.Search(len(t.next), func(i int) bool {
+		return t.next[i].letter >= letter
+	})
+}
+
+// Returns the number of nodes in the trie, including t.
+func (t *TrieNode) Size() int {
+	n := 1
+	for _, c := range t.next {
+		n += c.Size()
+	}
+	return n
+}
+
+// How a word of a query matched a scene.
+type wordMatch struct {
+	fields Field // zero if the word didn't match
+	dist   int   // the edit distance to the closest word it matched
+}
+
+// Parses str into terms (see apiserver_query.go) and traverses the trie with every word.
+// Words within maxDist edits are also found, see apiserver_fuzzy.go. If matchAll is set only
+// the scenes found for every term are listed (intersection), otherwise the scenes found for any
+// term (union). From these scenes a list of movies is composed which is also part of the result.
+// The results are ranked, see apiserver_rank.go.
 // Used by search handler.
-func (t *TrieNode) Get(ad *sfmovies.APIData, str string) *SearchResults {
-	scenes := t.recursiveGet(CleanString(str))
-	if scenes == nil {
+func (t *TrieNode) Get(ad *sfmovies.APIData, str string, matchAll bool, maxDist int) *SearchResults {
+	terms := ParseQuery(str)
+	if len(terms) == 0 {
 		return nil
 	}
+	words := make([]string, 0)
+	scopes := make([]Field, 0)
+	for _, term := range terms {
+		scope := term.Field
+		if scope == 0 {
+			scope = ^Field(0)
+		}
+		for _, word := range term.Words {
+			words = append(words, word)
+			scopes = append(scopes, scope)
+		}
+	}
 
-	r := new(SearchResults)
-	r.Scenes = scenes
-
-	// remove dups from movies
-	M := make(map[string]bool)
-	for _, scene := range scenes {
-		M[scene.IMDBID] = true
+	// for every scene how each of the words matched
+	found := make(map[*sfmovies.Scene][]wordMatch)
+	for i, word := range words {
+		for _, m := range t.FuzzyFind(word, allowedEdits(word, maxDist), false) {
+			for j, scene := range m.node.scenes {
+				fields := m.node.fields[j] & scopes[i]
+				if fields == 0 {
+					continue
+				}
+				if found[scene] == nil {
+					found[scene] = make([]wordMatch, len(words))
+				}
+				wm := &found[scene][i]
+				switch {
+				case wm.fields == 0 || m.dist < wm.dist:
+					*wm = wordMatch{fields, m.dist}
+				case m.dist == wm.dist:
+					wm.fields |= fields
+				}
+			}
+		}
 	}
-	r.Movies = make([]*sfmovies.Movie, 0)
-	for imdbid := range M {
-		if movie, ok := ad.Movies[imdbid]; ok {
-			r.Movies = append(r.Movies, movie)
+
+	for scene, wms := range found {
+		matched := 0
+		for _, term := range terms {
+			if termMatches(ad, scene, term, wms[:len(term.Words)]) {
+				matched++
+			}
+			wms = wms[len(term.Words):]
+		}
+		if matched == 0 || matchAll && matched < len(terms) {
+			delete(found, scene)
 		}
 	}
+	if len(found) == 0 {
+		return nil
+	}
 
-	return r
+	return rankResults(ad, words, found)
 }
 
-// Recursively traverses the trie with str and returns the scenes stored in this node.
-func (t *TrieNode) recursiveGet(str string) []*sfmovies.Scene {
-	if len(str) == 0 {
-		return t.scenes
+// Checks if all words of the term matched the scene. The words of a phrase also have to
+// occur next to each other. If the term doesn't match, its word matches are cleared.
+func termMatches(ad *sfmovies.APIData, scene *sfmovies.Scene, term QueryTerm, wms []wordMatch) bool {
+	var fields Field = ^Field(0)
+	exact := true
+	for _, wm := range wms {
+		fields &= wm.fields
+		exact = exact && wm.dist == 0
 	}
-	if ix, ok := toIndex(str[0]); ok && t.next[ix] != nil {
-		return t.next[ix].recursiveGet(str[1:])
+	// phrases with typos are not checked for the order of the words
+	if term.Phrase && exact {
+		fields = phraseFields(ad, scene, term.Words, fields)
 	}
-	return nil
+	if term.Phrase {
+		for i := range wms {
+			wms[i].fields &= fields
+		}
+	}
+	for _, wm := range wms {
+		if wm.fields == 0 {
+			for i := range wms {
+				wms[i] = wordMatch{}
+			}
+			return false
+		}
+	}
+	return true
 }
 
 // Returns a list of words in the try starting with str.
-// Used by auto-complete handler.
 func (t *TrieNode) GetFrom(str string, amount int) []string {
-	str = CleanString(str)
-	return t.recursiveGetFrom(str, amount)
+	r := make([]string, 0)
+	n := t
+	for _, c := range CleanString(str) {
+		if n = n.child(c); n == nil {
+			return r
+		}
+	}
+	n.BFS(&r, amount)
+	return r
 }
 
-// Recursively traverses the trie with str. The amount parameter is passed along.
-func (t *TrieNode) recursiveGetFrom(str string, amount int) []string {
-	r := make([]string, 0)
-	if len(str) == 0 {
-		t.BFS(&r, amount)
+// Returns at most amount display strings that match str. The last word of str may be the
+// start of a word in the display string, the other words have to match complete words.
+// The last word may also be within maxDist edits of the start of a word.
+// Strings containing a word that exactly matches the last word come first, then strings
+// that start with the last word, then the strings that need the fewest edits.
+// Used by auto-complete handler.
+func (t *TrieNode) Complete(str string, amount int, maxDist int) []*Completion {
+	r := make([]*Completion, 0)
+	words := strings.Fields(CleanString(str))
+	if len(words) == 0 {
 		return r
 	}
+	last := words[len(words)-1]
 
-	if ix, ok := toIndex(str[0]); ok && t.next[ix] != nil {
-		return t.next[ix].recursiveGetFrom(str[1:], amount)
+	seen := make(map[*Completion]bool)
+	for _, m := range t.FuzzyFind(last, allowedEdits(last, maxDist), true) {
+		if len(r) == amount {
+			break
+		}
+		m.node.collectPhrases(&r, seen, words[:len(words)-1], amount)
 	}
 	return r
 }
 
+// Does a breadth first search from the node. Appends the display strings that contain all
+// words to r and stops when r holds amount strings.
+func (t *TrieNode) collectPhrases(r *[]*Completion, seen map[*Completion]bool, words []string, amount int) {
+	q := []*TrieNode{t}
+	for len(q) != 0 && len(*r) < amount {
+		// pop first element from the queue
+		n := q[0]
+		q = q[1:]
+
+		q = append(q, n.next...)
+
+		for _, c := range n.phrases {
+			if seen[c] || !containsWords(c.Text, words) {
+				continue
+			}
+			seen[c] = true
+			*r = append(*r, c)
+			if len(*r) == amount {
+				break
+			}
+		}
+	}
+}
+
+// Checks if every word occurs as a complete word in the cleaned up str.
+func containsWords(str string, words []string) bool {
+	if len(words) == 0 {
+		return true
+	}
+	M := make(map[string]bool)
+	for _, w := range strings.Fields(CleanString(str)) {
+		M[w] = true
+	}
+	for _, w := range words {
+		if !M[w] {
+			return false
+		}
+	}
+	return true
+}
+
 // Does a breadth first search from the node. Stores full words in r and stops when the amount is reached.
 func (t *TrieNode) BFS(r *[]string, amount int) {
 	q := make([]*TrieNode, 0)
@@ -155,11 +416,7 @@ func (t *TrieNode) BFS(r *[]string, amount int) {
 		q = q[1:]
 
 		// add all next nodes to the queue
-		for _, m := range n.next {
-			if m != nil {
-				q = append(q, m)
-			}
-		}
+		q = append(q, n.next...)
 
 		// don't add results smaller than 3 chars
 		if n.prev == nil || n.prev.prev == nil || n.prev.prev.prev == nil {
@@ -187,27 +444,41 @@ func (t *TrieNode) String() string {
 	}
 }
 
-// Remove all non a-z and non whitespace chars
+// Normalizes str so that it can be stored in the trie. The string is decomposed (NFKD) so that
+// diacritics can be stripped and compatibility characters like ligatures are expanded, e.g.
+// "Zoë" becomes "zoe" and "ﬁ" becomes "fi". Letters that don't decompose are folded by the
+// foldings table. Then everything is lower cased and all chars that are not letters, digits or
+// whitespace are removed. The same normalization is used for indexing and for queries.
 func CleanString(str string) string {
-	str = strings.ToLower(str)
-	clean := make([]rune, 0)
+	str = norm.NFKD.String(str)
+	clean := make([]rune, 0, len(str))
 	for _, r := range str {
+		if unicode.Is(unicode.Mn, r) {
+			// diacritic
+			continue
+		}
 		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsDigit(r) {
-			clean = append(clean, r)
+			r = unicode.ToLower(r)
+			if f, ok := foldings[r]; ok {
+				clean = append(clean, []rune(f)...)
+			} else {
+				clean = append(clean, r)
+			}
 		}
 	}
 	return string(clean)
 }
 
-// Returns index of [a-z, 0-9].
-func toIndex(c byte) (int, bool) {
-	ix := int(c - 'a')
-	if ix >= 0 && ix < 26 {
-		return ix, true
-	}
-	ix = int(c - '0')
-	if ix >= 0 && ix < 10 {
-		return ix + 26, true
-	}
-	return -1, false
+// Lower case letters that are not decomposed by NFKD and their ASCII equivalents.
+var foldings = map[rune]string{
+	'æ': "ae",
+	'œ': "oe",
+	'ß': "ss",
+	'ø': "o",
+	'đ': "d",
+	'ð': "d",
+	'þ': "th",
+	'ł': "l",
+	'ı': "i",
+	'ħ': "h",
 }
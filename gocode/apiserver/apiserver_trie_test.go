@@ -16,6 +16,11 @@ func TestCleanString(t *testing.T) {
 		{"abc def ghi xyz 123 456 ab12", "abc def ghi xyz 123 456 ab12"},
 		{"ABC DEF GhI xYz 123 456 AB12", "abc def ghi xyz 123 456 ab12"},
 		{"me^&sf asf7 H3r 78fn#$^ewn4#^yu f d88+_\\:}{\"j", "mesf asf7 h3r 78fnewn4yu f d88j"},
+		{"Zoë Café Crème Brûlée", "zoe cafe creme brulee"},
+		{"ÅNGSTRÖM Ñandú", "angstrom nandu"},
+		{"Ærø Œuvre Straße Łódź Søren", "aero oeuvre strasse lodz soren"},
+		{"ﬁlm ﬂoor Ⅻ ²", "film floor xii 2"},
+		{"Ｆｕｌｌｗｉｄｔｈ １２３", "fullwidth 123"},
 	}
 	for _, c := range cases {
 		got := CleanString(c.in)
@@ -25,33 +30,32 @@ func TestCleanString(t *testing.T) {
 	}
 }
 
-func TestToIndex(t *testing.T) {
-	cases := []struct {
-		in   byte
-		out1 int
-		out2 bool
-	}{
-		{'a', 0, true},
-		{'f', 5, true},
-		{'x', 23, true},
-		{'y', 24, true},
-		{'z', 25, true},
-		{'0', 26, true},
-		{'5', 31, true},
-		{'9', 35, true},
-		{' ', -1, false},
-		{'*', -1, false},
-		{'_', -1, false},
+func TestTrieNodeChild(t *testing.T) {
+	n := NewTrieNode()
+	for _, r := range "zoë9a1ßb" {
+		n.addChild(r)
 	}
-	for _, c := range cases {
-		got1, got2 := toIndex(c.in)
-		if got1 != c.out1 {
-			t.Errorf("toIndex(%v) == %v, want %v", c.in, got1, c.out2)
+	n.addChild('a')
+
+	// children are unique and sorted by letter
+	want := []rune("19abozßë")
+	if len(n.next) != len(want) {
+		t.Fatalf("Node has %v children, want %v", len(n.next), len(want))
+	}
+	for i, r := range want {
+		if n.next[i].letter != r {
+			t.Errorf("Child %v is %q, want %q", i, n.next[i].letter, r)
+		}
+		if n.child(r) != n.next[i] {
+			t.Errorf("child(%q) is not the child with that letter", r)
 		}
-		if got2 != c.out2 {
-			t.Errorf("toIndex(%v) == %v, want %v", c.in, got2, c.out2)
+		if n.next[i].prev != n {
+			t.Errorf("Child %q does not point to its parent", r)
 		}
 	}
+	if n.child('c') != nil || n.child('_') != nil {
+		t.Errorf("child returned a node that was not added")
+	}
 }
 
 func TestTrieNodeString(t *testing.T) {
@@ -69,14 +73,14 @@ func TestTrieNodeString(t *testing.T) {
 		out string
 	}{
 		{n, ""},
-		{n.next[0], "a"},
-		{n.next[27], "1"},
-		{n.next[0].next[1], "ab"},
-		{n.next[0].next[1].next[2], "abc"},
-		{n.next[0].next[1].next[2].next[3].next[4].next[5], "abcdef"},
-		{n.next[0].next[1].next[2].next[3].next[4].next[5], "abcdef"},
-		{n.next[0].next[1].next[2].next[0].next[1].next[2], "abcabc"},
-		{n.next[27].next[28].next[29].next[30], "1234"},
+		{n.child('a'), "a"},
+		{n.child('1'), "1"},
+		{n.child('a').child('b'), "ab"},
+		{n.child('a').child('b').child('c'), "abc"},
+		{n.child('a').child('b').child('c').child('d').child('e').child('f'), "abcdef"},
+		{n.child('a').child('b').child('c').child('d').child('e').child('f'), "abcdef"},
+		{n.child('a').child('b').child('c').child('a').child('b').child('c'), "abcabc"},
+		{n.child('1').child('2').child('3').child('4'), "1234"},
 	}
 	for _, c := range cases {
 		got := c.in.String()
@@ -89,10 +93,8 @@ func TestTrieNodeString(t *testing.T) {
 func TestNewTrieNode(t *testing.T) {
 	// Empty trie
 	n := NewTrieNode()
-	for _, node := range n.next {
-		if node != nil {
-			t.Errorf("Node has children")
-		}
+	if len(n.next) != 0 {
+		t.Errorf("Node has children")
 	}
 }
 
@@ -109,11 +111,11 @@ func TestTrieNodeAdd(t *testing.T) {
 	cases := []struct {
 		s1, s2 *sfmovies.Scene
 	}{
-		{n.next[0].next[1].next[2].scenes[0], scene1},
-		{n.next[0].next[1].next[2].next[3].next[4].next[5].scenes[0], scene2},
-		{n.next[0].next[1].next[2].next[0].next[1].next[2].scenes[0], scene1},
-		{n.next[27].next[28].next[29].next[30].scenes[0], scene2},
-		{n.next[0].next[1].next[2].next[0].next[1].next[2].scenes[1], scene2},
+		{n.child('a').child('b').child('c').scenes[0], scene1},
+		{n.child('a').child('b').child('c').child('d').child('e').child('f').scenes[0], scene2},
+		{n.child('a').child('b').child('c').child('a').child('b').child('c').scenes[0], scene1},
+		{n.child('1').child('2').child('3').child('4').scenes[0], scene2},
+		{n.child('a').child('b').child('c').child('a').child('b').child('c').scenes[1], scene2},
 	}
 
 	for _, c := range cases {
@@ -133,13 +135,13 @@ func TestTrieNodeAddMessyString(t *testing.T) {
 	cases := []struct {
 		s1, s2 *sfmovies.Scene
 	}{
-		{n.next[0].next[1].next[2].scenes[0], scene1},
-		{n.next[0].next[1].next[2].scenes[1], scene2},
-		{n.next[3].next[4].next[5].scenes[0], scene1},
-		{n.next[3].next[4].next[5].next[6].scenes[0], scene2},
-		{n.next[6].next[7].next[8].scenes[0], scene1},
-		{n.next[6].next[7].next[8].scenes[1], scene2},
-		{n.next[29].next[28].next[27].scenes[0], scene2},
+		{n.child('a').child('b').child('c').scenes[0], scene1},
+		{n.child('a').child('b').child('c').scenes[1], scene2},
+		{n.child('d').child('e').child('f').scenes[0], scene1},
+		{n.child('d').child('e').child('f').child('g').scenes[0], scene2},
+		{n.child('g').child('h').child('i').scenes[0], scene1},
+		{n.child('g').child('h').child('i').scenes[1], scene2},
+		{n.child('3').child('2').child('1').scenes[0], scene2},
 	}
 
 	for _, c := range cases {
@@ -186,3 +188,188 @@ func TestTrieNodeBFS(t *testing.T) {
 		}
 	}
 }
+
+func TestTrieNodeGet(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = &sfmovies.Movie{IMDBID: "tt1", Title: "Golden Gate"}
+	ad.Movies["tt2"] = &sfmovies.Movie{IMDBID: "tt2", Title: "Vertigo"}
+	scene1 := &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "Golden Gate Bridge"}}
+	scene2 := &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "Golden Gate Park"}}
+	scene3 := &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "Fort Point"}}
+	ad.Scenes["1"], ad.Scenes["2"], ad.Scenes["3"] = scene1, scene2, scene3
+	n := CreateTrie(ad)
+
+	cases := []struct {
+		q        string
+		matchAll bool
+		scenes   []*sfmovies.Scene
+		movies   int
+	}{
+		{"", true, nil, 0},
+		{"unknown", true, nil, 0},
+		{"golden", true, []*sfmovies.Scene{scene1, scene2}, 2},
+		{"golden gate", true, []*sfmovies.Scene{scene1, scene2}, 2},
+		{"  Golden   GATE!", true, []*sfmovies.Scene{scene1, scene2}, 2},
+		{"golden gate bridge", true, []*sfmovies.Scene{scene1}, 1},
+		{"gate point", true, nil, 0},
+		{"gate point", false, []*sfmovies.Scene{scene1, scene2, scene3}, 2},
+		{"bridge park unknown", false, []*sfmovies.Scene{scene1, scene2}, 2},
+		{"vertigo park", true, []*sfmovies.Scene{scene2}, 1},
+	}
+	for _, c := range cases {
+		got := n.Get(ad, c.q, c.matchAll, 0)
+		if got == nil {
+			if c.scenes != nil {
+				t.Errorf("Get(%q, %v) == nil, want %v scenes", c.q, c.matchAll, len(c.scenes))
+			}
+			continue
+		}
+		M := make(map[*sfmovies.Scene]bool)
+		for _, scene := range got.Scenes {
+			M[scene.Scene] = true
+		}
+		if len(got.Scenes) != len(c.scenes) || len(M) != len(c.scenes) {
+			t.Errorf("Get(%q, %v) returned %v scenes, want %v", c.q, c.matchAll, len(got.Scenes), len(c.scenes))
+		}
+		for _, scene := range c.scenes {
+			if !M[scene] {
+				t.Errorf("Get(%q, %v) does not contain %v", c.q, c.matchAll, scene.Name)
+			}
+		}
+		if len(got.Movies) != c.movies {
+			t.Errorf("Get(%q, %v) returned %v movies, want %v", c.q, c.matchAll, len(got.Movies), c.movies)
+		}
+	}
+}
+
+func TestTrieNodeComplete(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = (&sfmovies.MovieView{
+		IMDBID:   "tt1",
+		Title:    "Mrs. Doubtfire",
+		Director: "Chris Columbus",
+		Writer:   "Anne Fine (novel), Randi Mayem Singer (screenplay)",
+		Actors:   "Robin Williams, Sally Field, Pierce Brosnan",
+	}).Movie()
+	ad.Movies["tt2"] = (&sfmovies.MovieView{
+		IMDBID: "tt2",
+		Title:  "Bulletproof",
+		Actors: "Damon Wayans, Adam Sandler, James Caan",
+	}).Movie()
+	ad.Scenes["1"] = &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "2640 Steiner Street"}}
+	ad.Scenes["2"] = &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "Steiner Street"}}
+	ad.Scenes["3"] = &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "2640 Steiner Street"}}
+	n := CreateTrie(ad)
+
+	cases := []struct {
+		in  string
+		out []Completion
+	}{
+		{"", []Completion{}},
+		{"xyz", []Completion{}},
+		{"adam", []Completion{{"Adam Sandler", TypeActor}}},
+		{"ADA", []Completion{{"Adam Sandler", TypeActor}}},
+		{"mrs", []Completion{{"Mrs. Doubtfire", TypeTitle}}},
+		{"doubt", []Completion{{"Mrs. Doubtfire", TypeTitle}}},
+		{"columbus", []Completion{{"Chris Columbus", TypeDirector}}},
+		{"singer", []Completion{{"Randi Mayem Singer", TypeWriter}}},
+		{"robin w", []Completion{{"Robin Williams", TypeActor}}},
+		{"robin s", []Completion{}},
+		{"2640", []Completion{{"2640 Steiner Street", TypeLocation}}},
+		{"steiner", []Completion{{"2640 Steiner Street", TypeLocation}, {"Steiner Street", TypeLocation}}},
+	}
+	for _, c := range cases {
+		got := n.Complete(c.in, 10, 0)
+		if len(got) != len(c.out) {
+			t.Errorf("Complete(%q) returned %v results, want %v", c.in, len(got), len(c.out))
+			continue
+		}
+		M := make(map[Completion]bool)
+		for _, g := range got {
+			M[*g] = true
+		}
+		for _, want := range c.out {
+			if !M[want] {
+				t.Errorf("Complete(%q) does not contain %v", c.in, want)
+			}
+		}
+	}
+
+	// Exact word matches come first and the amount is respected
+	got := n.Complete("s", 3, 0)
+	if len(got) != 3 {
+		t.Errorf("Got: %v, want %v", len(got), 3)
+	}
+	got = n.Complete("sally", 10, 0)
+	if len(got) != 1 || got[0].Text != "Sally Field" {
+		t.Errorf("Complete(%q) == %v, want %v", "sally", got, "Sally Field")
+	}
+}
+
+func TestTrieNodeUnicode(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = (&sfmovies.MovieView{IMDBID: "tt1", Title: "Café Society", Actors: "Zoë Saldaña"}).Movie()
+	scene := &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "Caffè Trieste"}}
+	ad.Scenes["1"] = scene
+	n := CreateTrie(ad)
+
+	for _, q := range []string{"cafe", "Café", "CAFÉ", "zoe", "Zoë", "saldana", "caffe trieste"} {
+		got := n.Get(ad, q, true, 0)
+		if got == nil || len(got.Scenes) != 1 || got.Scenes[0].Scene != scene {
+			t.Errorf("Get(%q) did not find the scene", q)
+		}
+	}
+
+	// display strings keep their original spelling
+	got := n.Complete("zoe", 10, 0)
+	if len(got) != 1 || got[0].Text != "Zoë Saldaña" {
+		t.Errorf("Complete(%q) == %v, want %v", "zoe", got, "Zoë Saldaña")
+	}
+}
+
+func TestTrieYearRange(t *testing.T) {
+	ad := sfmovies.NewAPIData()
+	ad.Movies["tt1"] = (&sfmovies.MovieView{IMDBID: "tt1", Title: "Sense8", Year: "2015–2018"}).Movie()
+	ad.Movies["tt2"] = (&sfmovies.MovieView{IMDBID: "tt2", Title: "Vertigo", Year: "1958"}).Movie()
+	ad.Movies["tt3"] = (&sfmovies.MovieView{IMDBID: "tt3", Title: "Running", Year: "2017–"}).Movie()
+	sense8 := &sfmovies.Scene{IMDBID: "tt1", Location: &sfmovies.Location{Name: "Mission"}}
+	vertigo := &sfmovies.Scene{IMDBID: "tt2", Location: &sfmovies.Location{Name: "Fort Point"}}
+	running := &sfmovies.Scene{IMDBID: "tt3", Location: &sfmovies.Location{Name: "Presidio"}}
+	ad.Scenes["1"], ad.Scenes["2"], ad.Scenes["3"] = sense8, vertigo, running
+	n := CreateTrie(ad)
+
+	cases := []struct {
+		q      string
+		scenes []*sfmovies.Scene
+	}{
+		{"2015", []*sfmovies.Scene{sense8}},
+		{"year:2016", []*sfmovies.Scene{sense8}},
+		{"year:2018", []*sfmovies.Scene{sense8}},
+		{"year:2017", []*sfmovies.Scene{sense8, running}},
+		{"year:1958", []*sfmovies.Scene{vertigo}},
+		{"20152018", nil},
+		{"year:2019", nil},
+	}
+	for _, c := range cases {
+		got := n.Get(ad, c.q, true, 0)
+		var scenes []*sfmovies.Scene
+		if got != nil {
+			for _, scene := range got.Scenes {
+				scenes = append(scenes, scene.Scene)
+			}
+		}
+		if len(scenes) != len(c.scenes) {
+			t.Errorf("Get(%q) returned %v scenes, want %v", c.q, len(scenes), len(c.scenes))
+			continue
+		}
+		for _, scene := range c.scenes {
+			found := false
+			for _, s := range scenes {
+				found = found || s == scene
+			}
+			if !found {
+				t.Errorf("Get(%q) does not contain %v", c.q, scene.Name)
+			}
+		}
+	}
+}
@@ -20,12 +20,27 @@ var (
 	GeocodingURLSuffix = ",+San+Francisco,+CA&" + bounds + "&key=" + GeocodingKey
 )
 
-// The size of the response of the queries handled by the API server.
+// The size of the response of the queries handled by the API server. The limit parameter
+// changes the size up to the maximum.
 const (
-	NearQuerySize         = 20
-	AutoCompleteQuerySize = 10
+	SearchQuerySize          = 100
+	MaxSearchQuerySize       = 1000
+	NearQuerySize            = 20
+	MaxNearQuerySize         = 1000
+	AutoCompleteQuerySize    = 10
+	MaxAutoCompleteQuerySize = 100
+	WithinQuerySize          = 100
+	MaxWithinQuerySize       = 1000
+	PeopleQuerySize          = 20
+	MaxPeopleQuerySize       = 1000
+	SceneQuerySize           = 100
+	MaxSceneQuerySize        = 1000
 )
 
+// The maximum number of typos corrected per word in search and auto-complete queries.
+// Short words allow fewer typos.
+const MaxEditDistance = 2
+
 // San Francisco Bounds.
 const (
 	MinLat = 37.571026
@@ -41,9 +56,25 @@ var Usage = strings.Replace(fmt.Sprintf(
   "api_examples": {
     "{{.}}/status":                     "the status of the api server that handled the request",
     "{{.}}/movies/tt0028216":           "movie info of the specified IMDB ID",
-    "{{.}}/complete?term=franc":        "auto complete results for the specified term parameter",
+    "{{.}}/movies/tt0028216/scenes":    "the scenes of the specified IMDB ID with their scene and location IDs",
+    "{{.}}/scenes/{id}":                "the scene with the specified ID and its movie",
+    "{{.}}/locations/{id}":             "every movie and scene filmed at the location with the specified ID",
+    "{{.}}/people?q=robin":             "searches for actors, directors and writers by name, use limit to change the number of results",
+    "{{.}}/people/robin-williams":      "the roles, movies and film locations of the specified person",
+    "{{.}}/complete?term=franc":        "auto complete titles, names and locations for the specified term parameter",
     "{{.}}/search?q=francisco":         "searches for movie title, film location, release year, director, production company, distributer, writer and actors",
-    "{{.}}/near?lat=37.76&lng=-122.39": "searches for film locations near the presented gps coordinates"
-    "{{.}}/?callback=XXX":              "use the callback parameter on any request to return JSONP in stead of just JSON"
+    "{{.}}/search?q=golden+gate&op=or": "searches for scenes matching every word, or any word with op=or",
+    "{{.}}/search?q=actor:\"robin williams\" year:1993": "scopes search terms to the title, year, writer, director, actor or location field, quote phrases",
+    "{{.}}/search?q=fransisco&fuzzy=1": "corrects at most fuzzy typos per word in search and auto-complete queries, fuzzy=0 disables it",
+    "{{.}}/search?q=bridge&genre=thriller&year_from=1950&year_to=1979": "filters search and near results on year_from, year_to, genre (comma separated), rated, director and imdb_id",
+    "{{.}}/near?lat=37.76&lng=-122.39": "searches for film locations near the presented gps coordinates",
+    "{{.}}/near?lat=37.76&lng=-122.39&radius=500&limit=50": "searches for at most limit film locations within radius meters",
+    "{{.}}/within?minLat=37.75&minLng=-122.42&maxLat=37.77&maxLng=-122.39": "lists film locations within the bounding box",
+    "{{.}}/search?q=francisco&limit=10&cursor=XXX": "every list is paged, use limit to change the page size and the returned Cursor or Next link to get the next page",
+    "{{.}}/near?lat=37.76&lng=-122.39&format=geojson": "returns the scenes of search, near, within and scene requests as GeoJSON, or send Accept: application/geo+json",
+    "{{.}}/movies/tt0028216/scenes?format=kml": "exports the same scenes as KML placemarks for Google Earth, or as GPX waypoints with format=gpx",
+    "{{.}}/export.csv":                 "downloads every scene joined with its movie as CSV, or as newline delimited JSON from /export.ndjson",
+    "{{.}}/search?q=francisco&pretty=1": "returns indented JSON instead of compact JSON",
+    "{{.}}/?callback=XXX":              "use the callback parameter on any request to return JSONP in stead of just JSON, CORS is supported as well"
   }
 }`, APIVersion), "{{.}}", HostName, -1)
@@ -3,10 +3,15 @@
 package sfmovies
 
 import (
+	"errors"
 	"math"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // Stores all the information necessary to start an API webserver.
@@ -23,26 +28,61 @@ func NewAPIData() *APIData {
 	return r
 }
 
-type Movie struct {
-	Title    string
-	Year     string
-	Rated    string
-	Released string
-	Runtime  string
-	Genre    string
-	Director string
-	Writer   string
-	Actors   string
-	Plot     string
-	Poster   string
-	IMDBID   string `json: imdbID`
-}
-
 type Scene struct {
 	IMDBID string
 	*Location
 }
 
+// A range of years, e.g. the years a series ran. To equals From for a single year and
+// is zero if the range is open ended.
+type YearRange struct {
+	From int
+	To   int
+}
+
+// Parses a year as returned by OMDB: "1958", "2008–2012" or "2008–".
+func ParseYearRange(str string) (YearRange, error) {
+	var yr YearRange
+	str = strings.TrimSpace(str)
+	i := strings.IndexAny(str, "–-")
+	if i == -1 {
+		from, err := strconv.Atoi(str)
+		return YearRange{from, from}, err
+	}
+
+	var err error
+	yr.From, err = strconv.Atoi(strings.TrimSpace(str[:i]))
+	if err != nil {
+		return yr, err
+	}
+	_, size := utf8.DecodeRuneInString(str[i:])
+	if to := strings.TrimSpace(str[i+size:]); to != "" {
+		yr.To, err = strconv.Atoi(to)
+		if err == nil && yr.To < yr.From {
+			err = errors.New("year range ends before it starts: " + str)
+		}
+	}
+	return yr, err
+}
+
+// Formats the range the way OMDB does. Returns "N/A" for the zero range.
+func (yr YearRange) String() string {
+	switch {
+	case yr.From == 0:
+		return "N/A"
+	case yr.To == yr.From:
+		return strconv.Itoa(yr.From)
+	case yr.To == 0:
+		return strconv.Itoa(yr.From) + "–"
+	}
+	return strconv.Itoa(yr.From) + "–" + strconv.Itoa(yr.To)
+}
+
+// Checks if the ranges have a year in common.
+func (yr YearRange) Overlaps(o YearRange) bool {
+	return (yr.To == 0 || o.From <= yr.To) && (o.To == 0 || yr.From <= o.To)
+}
+
 // The location name is converted into lat, lng coordinates by the google geoencoding api
 type Location struct {
 	Name string
@@ -65,6 +105,26 @@ func (l1 *Location) Distance(l2 *Location) float64 {
 	return math.Sqrt(dX*dX + dY*dY)
 }
 
+// The mean radius of the earth in meters.
+const EarthRadius = 6371000
+
+// The great-circle distance in meters, calculated with the haversine formula.
+func (l1 *Location) DistanceMeters(l2 *Location) float64 {
+	lat1 := l1.Lat / 180 * math.Pi
+	lat2 := l2.Lat / 180 * math.Pi
+	dLat := lat2 - lat1
+	dLng := (l2.Lng - l1.Lng) / 180 * math.Pi
+
+	h := hav(dLat) + math.Cos(lat1)*math.Cos(lat2)*hav(dLng)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(math.Min(h, 1)))
+}
+
+// The haversine function: hav(x) = sin^2(x/2).
+func hav(x float64) float64 {
+	s := math.Sin(x / 2)
+	return s * s
+}
+
 // Checks if the coordinates are within San Francisco. The bounds are set in config.go.
 func (loc *Location) IsInBounds() bool {
 	return (MinLat <= loc.Lat && loc.Lat <= MaxLat) &&
@@ -89,6 +149,25 @@ func GetLatestAPIData() (*APIData, error) {
 	return &ad, nil
 }
 
+// Fetches only the time of the latest APIData from MongoDB. This is a cheap query that
+// API servers use to find out whether a newer data set is available.
+func GetLatestAPIDataTime() (time.Time, error) {
+	session, err := mgo.Dial(MongoURL)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer session.Close()
+
+	c := session.DB("apiserverdb").C("apiserverdata")
+
+	var ad APIData
+	err = c.Find(nil).Select(bson.M{"time": 1}).Sort("-time").One(&ad)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ad.Time, nil
+}
+
 // Stores the refereed APIData in MongoDB
 func StoreAPIData(ad *APIData) error {
 	session, err := mgo.Dial(MongoURL)
@@ -104,3 +183,36 @@ func StoreAPIData(ad *APIData) error {
 	}
 	return nil
 }
+
+// Rewrites every APIData snapshot in MongoDB in the current format. Snapshots stored before
+// the movie model was typed are decoded from the OMDB strings, see Movie.SetBSON.
+// Returns the number of snapshots that were rewritten.
+func MigrateAPIData() (int, error) {
+	session, err := mgo.Dial(MongoURL)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	c := session.DB("apiserverdb").C("apiserverdata")
+
+	n := 0
+	iter := c.Find(nil).Iter()
+	for {
+		// a fresh document every time, decoding into the maps of the previous one would merge them
+		var doc struct {
+			ID      bson.ObjectId `bson:"_id"`
+			APIData `bson:",inline"`
+		}
+		if !iter.Next(&doc) {
+			break
+		}
+		// updating is idempotent, so it doesn't matter if a moved document is visited twice
+		if err := c.UpdateId(doc.ID, &doc.APIData); err != nil {
+			iter.Close()
+			return n, err
+		}
+		n++
+	}
+	return n, iter.Close()
+}
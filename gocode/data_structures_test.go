@@ -0,0 +1,82 @@
+// Tests for data_structures.go
+package sfmovies
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLocationDistanceMeters(t *testing.T) {
+	cases := []struct {
+		l1, l2 Location
+		out    float64
+	}{
+		{Location{Lat: 37.7749, Lng: -122.4194}, Location{Lat: 37.7749, Lng: -122.4194}, 0},
+		// one degree of latitude
+		{Location{Lat: 37, Lng: -122}, Location{Lat: 38, Lng: -122}, 111195},
+		// Golden Gate Bridge to Coit Tower
+		{Location{Lat: 37.8199, Lng: -122.4783}, Location{Lat: 37.8024, Lng: -122.4058}, 6660},
+		// San Francisco to New York
+		{Location{Lat: 37.7749, Lng: -122.4194}, Location{Lat: 40.7128, Lng: -74.0060}, 4129086},
+		// antipodes
+		{Location{Lat: 0, Lng: 0}, Location{Lat: 0, Lng: 180}, math.Pi * EarthRadius},
+	}
+	for _, c := range cases {
+		got := c.l1.DistanceMeters(&c.l2)
+		if math.Abs(got-c.out) > 1 {
+			t.Errorf("%v.DistanceMeters(%v) == %v, want %v", c.l1, c.l2, got, c.out)
+		}
+		if back := c.l2.DistanceMeters(&c.l1); math.Abs(back-got) > 1e-6 {
+			t.Errorf("DistanceMeters is not symmetric: %v != %v", back, got)
+		}
+	}
+}
+
+func TestParseYearRange(t *testing.T) {
+	cases := []struct {
+		in  string
+		out YearRange
+		ok  bool
+	}{
+		{"1958", YearRange{1958, 1958}, true},
+		{" 1958 ", YearRange{1958, 1958}, true},
+		{"2008–2012", YearRange{2008, 2012}, true},
+		{"2008-2012", YearRange{2008, 2012}, true},
+		{"2008–", YearRange{2008, 0}, true},
+		{"2012–2008", YearRange{}, false},
+		{"N/A", YearRange{}, false},
+		{"", YearRange{}, false},
+	}
+	for _, c := range cases {
+		got, err := ParseYearRange(c.in)
+		if (err == nil) != c.ok {
+			t.Errorf("ParseYearRange(%q) returned error %v", c.in, err)
+		}
+		if c.ok && got != c.out {
+			t.Errorf("ParseYearRange(%q) == %v, want %v", c.in, got, c.out)
+		}
+	}
+}
+
+func TestYearRangeOverlaps(t *testing.T) {
+	cases := []struct {
+		a, b YearRange
+		out  bool
+	}{
+		{YearRange{1958, 1958}, YearRange{1958, 1958}, true},
+		{YearRange{1958, 1958}, YearRange{1970, 1979}, false},
+		{YearRange{1975, 1975}, YearRange{1970, 1979}, true},
+		{YearRange{1965, 1971}, YearRange{1970, 1979}, true},
+		{YearRange{2008, 0}, YearRange{2010, 2010}, true},
+		{YearRange{2008, 0}, YearRange{2000, 2007}, false},
+		{YearRange{1958, 1958}, YearRange{1950, 0}, true},
+	}
+	for _, c := range cases {
+		if got := c.a.Overlaps(c.b); got != c.out {
+			t.Errorf("%v.Overlaps(%v) == %v, want %v", c.a, c.b, got, c.out)
+		}
+		if got := c.b.Overlaps(c.a); got != c.out {
+			t.Errorf("%v.Overlaps(%v) == %v, want %v", c.b, c.a, got, c.out)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 // Updates the database with the latest API Data. This should be run once a day to keep the database up to date.
 // Fetches the source table, consults OMDB and Google Geo-Encoding API, and stores data in MongoDB
+// Run with -migrate to convert the stored data after the data structures changed.
 package main
 
 import (
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"hash/fnv"
 	"io/ioutil"
@@ -17,7 +19,19 @@ import (
 	"github.com/CorgiMan/sfmovies/gocode"
 )
 
+var migrate = flag.Bool("migrate", false, "rewrite the stored API data in the current format instead of updating it")
+
 func main() {
+	flag.Parse()
+	if *migrate {
+		n, err := sfmovies.MigrateAPIData()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Migrated", n, "API data snapshots")
+		return
+	}
+
 	apidata, err := GetAndParseAPIData()
 	if err != nil {
 		log.Fatal(err)
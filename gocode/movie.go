@@ -0,0 +1,278 @@
+// The movie model. OMDB returns every field of a movie as a string, e.g. "128 min" or
+// "Adam Sandler, Drew Barrymore". The strings are parsed once, when the movie is fetched or
+// loaded from an old snapshot, so the rest of the code can work with typed values.
+// The JSON representation of a movie is still the OMDB one, so API clients keep working.
+package sfmovies
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Movie struct {
+	Title     string
+	Year      YearRange // zero if unknown
+	Rated     string
+	Released  time.Time // zero if unknown
+	Runtime   int       // in minutes, zero if unknown
+	Genres    []string
+	Directors []Person
+	Writers   []Person
+	Actors    []Person
+	Plot      string
+	Poster    string
+	IMDBID    string
+}
+
+// Someone who worked on a movie. Note is the annotation OMDB adds to some names,
+// e.g. "screenplay" for "Alec Coppel (screenplay)".
+type Person struct {
+	Name string
+	Note string `bson:",omitempty"`
+}
+
+// Formats the person the way OMDB does.
+func (p Person) String() string {
+	if p.Note == "" {
+		return p.Name
+	}
+	return p.Name + " (" + p.Note + ")"
+}
+
+// Splits a comma separated list of names as returned by OMDB into people. Commas between
+// parentheses don't separate names. "N/A" means there are no names.
+func ParsePeople(str string) []Person {
+	people := make([]Person, 0)
+	depth, start := 0, 0
+	for i := 0; i <= len(str); i++ {
+		if i < len(str) {
+			switch str[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			}
+			if str[i] != ',' || depth > 0 {
+				continue
+			}
+		}
+		if p, ok := parsePerson(str[start:i]); ok {
+			people = append(people, p)
+		}
+		start = i + 1
+	}
+	return people
+}
+
+// Parses a single name with optional annotations between parentheses.
+func parsePerson(str string) (Person, bool) {
+	var name, note []rune
+	var notes []string
+	depth := 0
+	for _, r := range str {
+		switch {
+		case r == '(':
+			if depth > 0 {
+				note = append(note, r)
+			}
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+			if depth > 0 {
+				note = append(note, r)
+				break
+			}
+			if n := strings.Join(strings.Fields(string(note)), " "); n != "" {
+				notes = append(notes, n)
+			}
+			note = note[:0]
+		case depth > 0:
+			note = append(note, r)
+		default:
+			name = append(name, r)
+		}
+	}
+	n := strings.Join(strings.Fields(string(name)), " ")
+	if n == "" || n == "N/A" {
+		return Person{}, false
+	}
+	return Person{n, strings.Join(notes, ", ")}, true
+}
+
+// Returns the names of the people.
+func Names(people []Person) []string {
+	names := make([]string, len(people))
+	for i, p := range people {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Returns the names of the people with their annotations, e.g. "Alec Coppel (screenplay)".
+func PersonStrings(people []Person) []string {
+	strs := make([]string, len(people))
+	for i, p := range people {
+		strs[i] = p.String()
+	}
+	return strs
+}
+
+// The layout of release dates returned by OMDB.
+const ReleasedLayout = "02 Jan 2006"
+
+// Parses a runtime as returned by OMDB into minutes: "128 min", "2 h" or "1 h 30 min".
+func ParseRuntime(str string) (int, error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return 0, errors.New("malformed runtime: " + str)
+	}
+	minutes := 0
+	for i := 0; i < len(fields); i += 2 {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return 0, errors.New("malformed runtime: " + str)
+		}
+		switch fields[i+1] {
+		case "min":
+			minutes += n
+		case "h":
+			minutes += 60 * n
+		default:
+			return 0, errors.New("malformed runtime: " + str)
+		}
+	}
+	return minutes, nil
+}
+
+// A movie in the format returned by OMDB. This is what API clients get when they ask for a movie.
+type MovieView struct {
+	Title    string
+	Year     string
+	Rated    string
+	Released string
+	Runtime  string
+	Genre    string
+	Director string
+	Writer   string
+	Actors   string
+	Plot     string
+	Poster   string
+	IMDBID   string
+}
+
+// Formats the movie the way OMDB does. Unknown values become "N/A".
+func (m *Movie) View() *MovieView {
+	v := &MovieView{
+		Title:    m.Title,
+		Year:     m.Year.String(),
+		Rated:    orNA(m.Rated),
+		Released: "N/A",
+		Runtime:  "N/A",
+		Genre:    orNA(strings.Join(m.Genres, ", ")),
+		Director: orNA(joinPeople(m.Directors)),
+		Writer:   orNA(joinPeople(m.Writers)),
+		Actors:   orNA(joinPeople(m.Actors)),
+		Plot:     orNA(m.Plot),
+		Poster:   orNA(m.Poster),
+		IMDBID:   m.IMDBID,
+	}
+	if !m.Released.IsZero() {
+		v.Released = m.Released.Format(ReleasedLayout)
+	}
+	if m.Runtime > 0 {
+		v.Runtime = strconv.Itoa(m.Runtime) + " min"
+	}
+	return v
+}
+
+// Parses the OMDB strings. Values that can't be parsed, like "N/A", are left zero.
+func (v *MovieView) Movie() *Movie {
+	m := &Movie{
+		Title:     v.Title,
+		Rated:     notNA(v.Rated),
+		Genres:    Names(ParsePeople(v.Genre)), // genres are a comma separated list like names
+		Directors: ParsePeople(v.Director),
+		Writers:   ParsePeople(v.Writer),
+		Actors:    ParsePeople(v.Actors),
+		Plot:      notNA(v.Plot),
+		Poster:    notNA(v.Poster),
+		IMDBID:    v.IMDBID,
+	}
+	if yr, err := ParseYearRange(v.Year); err == nil {
+		m.Year = yr
+	}
+	if t, err := time.Parse(ReleasedLayout, v.Released); err == nil {
+		m.Released = t
+	}
+	if minutes, err := ParseRuntime(v.Runtime); err == nil {
+		m.Runtime = minutes
+	}
+	return m
+}
+
+func joinPeople(people []Person) string {
+	return strings.Join(PersonStrings(people), ", ")
+}
+
+func orNA(str string) string {
+	if str == "" {
+		return "N/A"
+	}
+	return str
+}
+
+func notNA(str string) string {
+	if str == "N/A" {
+		return ""
+	}
+	return str
+}
+
+// Movies are encoded as their view to stay compatible with existing API clients.
+func (m *Movie) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.View())
+}
+
+// Decodes a movie from the OMDB format. Used to read OMDB responses.
+func (m *Movie) UnmarshalJSON(data []byte) error {
+	var v MovieView
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = *v.Movie()
+	return nil
+}
+
+// The fields of a movie as they are stored in MongoDB. Used to decode without calling SetBSON again.
+type movieDoc Movie
+
+// Decodes a movie from MongoDB. Snapshots stored before the model was typed hold the OMDB
+// strings, they are recognized by their year being a string and parsed like an OMDB response.
+// Use MigrateAPIData to convert the stored snapshots.
+func (m *Movie) SetBSON(raw bson.Raw) error {
+	var probe struct{ Year interface{} }
+	if err := raw.Unmarshal(&probe); err != nil {
+		return err
+	}
+	if _, old := probe.Year.(string); old {
+		var v MovieView
+		if err := raw.Unmarshal(&v); err != nil {
+			return err
+		}
+		*m = *v.Movie()
+		return nil
+	}
+	if err := raw.Unmarshal((*movieDoc)(m)); err != nil {
+		return err
+	}
+	// dates are decoded in local time, which could shift the release date to the day before
+	m.Released = m.Released.UTC()
+	return nil
+}
@@ -0,0 +1,194 @@
+// Tests for movie.go
+package sfmovies
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParsePeople(t *testing.T) {
+	cases := []struct {
+		in  string
+		out []Person
+	}{
+		{"", []Person{}},
+		{"N/A", []Person{}},
+		{"Robin Williams, Sally Field", []Person{{"Robin Williams", ""}, {"Sally Field", ""}}},
+		{"Alec Coppel (screenplay), Samuel A. Taylor (screenplay)", []Person{{"Alec Coppel", "screenplay"}, {"Samuel A. Taylor", "screenplay"}}},
+		{"Bob Kane (characters, comic book), Jonathan Nolan (screenplay) (story)", []Person{{"Bob Kane", "characters, comic book"}, {"Jonathan Nolan", "screenplay, story"}}},
+		{"Pierre Boileau (based on the novel \"D'Entre Les Morts\" by)", []Person{{"Pierre Boileau", "based on the novel \"D'Entre Les Morts\" by"}}},
+	}
+	for _, c := range cases {
+		got := ParsePeople(c.in)
+		if !reflect.DeepEqual(got, c.out) {
+			t.Errorf("ParsePeople(%q) == %q, want %q", c.in, got, c.out)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	cases := []struct {
+		in  string
+		out []string
+	}{
+		{"", []string{}},
+		{"N/A", []string{}},
+		{"Alfred Hitchcock", []string{"Alfred Hitchcock"}},
+		{"Adam Sandler, Drew Barrymore, Rob Schneider, Sean Astin", []string{"Adam Sandler", "Drew Barrymore", "Rob Schneider", "Sean Astin"}},
+		{"Alec Coppel (screenplay), Samuel A. Taylor (screenplay), Pierre Boileau (based on the novel \"D'Entre Les Morts\" by)",
+			[]string{"Alec Coppel", "Samuel A. Taylor", "Pierre Boileau"}},
+		{" Robin  Williams ,, Sally Field", []string{"Robin Williams", "Sally Field"}},
+	}
+	for _, c := range cases {
+		got := Names(ParsePeople(c.in))
+		if len(got) != len(c.out) {
+			t.Errorf("Names(ParsePeople(%q)) == %q, want %q", c.in, got, c.out)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.out[i] {
+				t.Errorf("Names(ParsePeople(%q)) == %q, want %q", c.in, got, c.out)
+				break
+			}
+		}
+	}
+}
+
+func TestParseRuntime(t *testing.T) {
+	cases := []struct {
+		in  string
+		out int
+		err bool
+	}{
+		{"128 min", 128, false},
+		{"2 h", 120, false},
+		{"1 h 30 min", 90, false},
+		{"N/A", 0, true},
+		{"", 0, true},
+		{"128", 0, true},
+		{"two h", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ParseRuntime(c.in)
+		if (err != nil) != c.err || got != c.out {
+			t.Errorf("ParseRuntime(%q) == %v, %v, want %v", c.in, got, err, c.out)
+		}
+	}
+}
+
+func TestYearRangeString(t *testing.T) {
+	for _, in := range []string{"1958", "2008–2012", "2008–"} {
+		yr, err := ParseYearRange(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := yr.String(); got != in {
+			t.Errorf("%#v.String() == %q, want %q", yr, got, in)
+		}
+	}
+	if got := (YearRange{}).String(); got != "N/A" {
+		t.Errorf("YearRange{}.String() == %q, want %q", got, "N/A")
+	}
+}
+
+var vertigoView = MovieView{
+	Title:    "Vertigo",
+	Year:     "1958",
+	Rated:    "PG",
+	Released: "22 May 1958",
+	Runtime:  "128 min",
+	Genre:    "Mystery, Romance, Thriller",
+	Director: "Alfred Hitchcock",
+	Writer:   "Alec Coppel (screenplay), Samuel A. Taylor (screenplay)",
+	Actors:   "James Stewart, Kim Novak",
+	Plot:     "N/A",
+	Poster:   "N/A",
+	IMDBID:   "tt0052357",
+}
+
+var vertigo = Movie{
+	Title:     "Vertigo",
+	Year:      YearRange{1958, 1958},
+	Rated:     "PG",
+	Released:  time.Date(1958, 5, 22, 0, 0, 0, 0, time.UTC),
+	Runtime:   128,
+	Genres:    []string{"Mystery", "Romance", "Thriller"},
+	Directors: []Person{{"Alfred Hitchcock", ""}},
+	Writers:   []Person{{"Alec Coppel", "screenplay"}, {"Samuel A. Taylor", "screenplay"}},
+	Actors:    []Person{{"James Stewart", ""}, {"Kim Novak", ""}},
+	IMDBID:    "tt0052357",
+}
+
+func TestMovieView(t *testing.T) {
+	if got := vertigoView.Movie(); !reflect.DeepEqual(*got, vertigo) {
+		t.Errorf("MovieView.Movie() == %+v, want %+v", *got, vertigo)
+	}
+	if got := vertigo.View(); *got != vertigoView {
+		t.Errorf("Movie.View() == %+v, want %+v", *got, vertigoView)
+	}
+
+	// unknown values
+	empty := MovieView{"Untitled", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "tt0000001"}
+	if got := empty.Movie(); !reflect.DeepEqual(*got, Movie{Title: "Untitled", Genres: []string{}, Directors: []Person{}, Writers: []Person{}, Actors: []Person{}, IMDBID: "tt0000001"}) {
+		t.Errorf("MovieView.Movie() == %+v, want only the title and IMDB ID", *got)
+	}
+	if got := empty.Movie().View(); *got != empty {
+		t.Errorf("Movie.View() == %+v, want %+v", *got, empty)
+	}
+}
+
+func TestMovieJSON(t *testing.T) {
+	bts, err := json.Marshal(&vertigo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var view MovieView
+	if err := json.Unmarshal(bts, &view); err != nil {
+		t.Fatal(err)
+	}
+	if view != vertigoView {
+		t.Errorf("Movie is encoded as %s, want the OMDB format %+v", bts, vertigoView)
+	}
+
+	// OMDB responses use imdbID
+	omdb := `{"Title":"Vertigo","Year":"1958","Runtime":"128 min","Actors":"James Stewart, Kim Novak","imdbID":"tt0052357","Response":"True"}`
+	var m Movie
+	if err := json.Unmarshal([]byte(omdb), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.IMDBID != "tt0052357" || m.Runtime != 128 || len(m.Actors) != 2 || m.Year.From != 1958 {
+		t.Errorf("Decoded OMDB response into %+v", m)
+	}
+}
+
+func TestMovieSetBSON(t *testing.T) {
+	// snapshots stored before the model was typed
+	old, err := bson.Marshal(struct{ Movies map[string]*MovieView }{map[string]*MovieView{"tt0052357": &vertigoView}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ad APIData
+	if err := bson.Unmarshal(old, &ad); err != nil {
+		t.Fatal(err)
+	}
+	if got := ad.Movies["tt0052357"]; got == nil || !reflect.DeepEqual(*got, vertigo) {
+		t.Errorf("Decoded old snapshot into %+v, want %+v", got, vertigo)
+	}
+
+	// snapshots in the current format
+	bts, err := bson.Marshal(&ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var migrated APIData
+	if err := bson.Unmarshal(bts, &migrated); err != nil {
+		t.Fatal(err)
+	}
+	if got := migrated.Movies["tt0052357"]; got == nil || !reflect.DeepEqual(*got, vertigo) {
+		t.Errorf("Decoded snapshot into %+v, want %+v", got, vertigo)
+	}
+}